@@ -0,0 +1,237 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package archive reads and writes nekobin exports: tar files holding a
+// manifest, the metadata of every document as JSON lines, then each distinct
+// content once, named after its digest.
+//
+//	manifest.json
+//	documents.jsonl
+//	contents/<digest>
+//
+// Documents have no revisions: should they get some, they'll come with a new
+// version of the format.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	Format = "nekobin"
+	// Bumped on changes older versions of nekobin can't read
+	Version = 1
+
+	manifestName  = "manifest.json"
+	documentsName = "documents.jsonl"
+	contentsDir   = "contents/"
+)
+
+var ErrUnsupported = errors.New("unsupported archive")
+
+type Manifest struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Created   int64  `json:"created"`
+	Documents int    `json:"documents"`
+}
+
+// Document is the metadata of an archived document, its content being the
+// one with the same digest.
+type Document struct {
+	Key                string   `json:"key"`
+	Title              *string  `json:"title"`
+	Author             *string  `json:"author"`
+	Language           *string  `json:"language"`
+	LanguageConfidence *float64 `json:"language_confidence"`
+	Date               int      `json:"date"`
+	Views              int      `json:"views"`
+	Digest             string   `json:"digest"`
+	Length             int      `json:"length"`
+}
+
+// Writer writes an archive, in order: the manifest, the documents, then the
+// contents.
+type Writer struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{tw: tar.NewWriter(w), now: time.Now()}
+}
+
+func (w *Writer) WriteManifest(documents int) error {
+	manifest, err := json.Marshal(&Manifest{
+		Format:    Format,
+		Version:   Version,
+		Created:   w.now.Unix(),
+		Documents: documents,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return w.write(manifestName, strings.NewReader(string(manifest)), int64(len(manifest)))
+}
+
+// WriteDocuments writes size bytes of documents, as JSON lines.
+func (w *Writer) WriteDocuments(r io.Reader, size int64) error {
+	return w.write(documentsName, r, size)
+}
+
+func (w *Writer) WriteContent(digest string, r io.Reader, size int64) error {
+	return w.write(contentsDir+digest, r, size)
+}
+
+// Close finishes the archive, without closing the underlying writer.
+func (w *Writer) Close() error {
+	return w.tw.Close()
+}
+
+func (w *Writer) write(name string, r io.Reader, size int64) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  w.now,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w.tw, r, size); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Reader reads an archive, gzipped or not, in the order it was written.
+type Reader struct {
+	Manifest Manifest
+
+	tr *tar.Reader
+}
+
+// NewReader reads the manifest, failing with ErrUnsupported when the archive
+// isn't one of nekobin or is of a later version.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	// Gzip magic number
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+
+		r = gz
+	} else {
+		r = br
+	}
+
+	reader := &Reader{tr: tar.NewReader(r)}
+
+	manifest, err := reader.next(manifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.NewDecoder(manifest).Decode(&reader.Manifest); err != nil {
+		return nil, ErrUnsupported
+	}
+
+	if reader.Manifest.Format != Format || reader.Manifest.Version < 1 || reader.Manifest.Version > Version {
+		return nil, fmt.Errorf("%w: %s version %d", ErrUnsupported, reader.Manifest.Format, reader.Manifest.Version)
+	}
+
+	return reader, nil
+}
+
+// Documents reads the metadata of every document.
+func (r *Reader) Documents() (docs []*Document, err error) {
+	lines, err := r.next(documentsName)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(lines)
+
+	for {
+		doc := &Document{}
+
+		err := decoder.Decode(doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", documentsName, err)
+		}
+
+		docs = append(docs, doc)
+	}
+}
+
+// NextContent returns the next content, to be read before moving on, or
+// io.EOF past the last one.
+func (r *Reader) NextContent() (digest string, content io.Reader, size int64, err error) {
+	header, err := r.tr.Next()
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	if !strings.HasPrefix(header.Name, contentsDir) {
+		return "", nil, 0, fmt.Errorf("%w: unexpected %s", ErrUnsupported, header.Name)
+	}
+
+	return path.Base(header.Name), r.tr, header.Size, nil
+}
+
+func (r *Reader) next(name string) (io.Reader, error) {
+	header, err := r.tr.Next()
+
+	if err == io.EOF || err == nil && header.Name != name {
+		return nil, fmt.Errorf("%w: missing %s", ErrUnsupported, name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.tr, nil
+}
@@ -0,0 +1,167 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func writeArchive(t *testing.T, w io.Writer, docs string, contents map[string]string, order []string) {
+	ar := NewWriter(w)
+
+	if err := ar.WriteManifest(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ar.WriteDocuments(strings.NewReader(docs), int64(len(docs))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, digest := range order {
+		content := contents[digest]
+
+		if err := ar.WriteContent(digest, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ar.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	docs := `{"key":"abc","title":"hello","digest":"d1","length":5,"views":3,"date":1600000000}
+{"key":"def","digest":"d2","length":5}
+`
+	contents := map[string]string{"d1": "hello", "d2": "world"}
+
+	for _, gzipped := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+
+		if gzipped {
+			gz := gzip.NewWriter(buf)
+			writeArchive(t, gz, docs, contents, []string{"d1", "d2"})
+
+			if err := gz.Close(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writeArchive(t, buf, docs, contents, []string{"d1", "d2"})
+		}
+
+		ar, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ar.Manifest.Format != Format || ar.Manifest.Version != Version || ar.Manifest.Documents != 2 {
+			t.Errorf("gzipped %v: got manifest %+v", gzipped, ar.Manifest)
+		}
+
+		read, err := ar.Documents()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(read) != 2 || read[0].Key != "abc" || read[0].Title == nil || *read[0].Title != "hello" ||
+			read[0].Views != 3 || read[0].Date != 1600000000 || read[1].Key != "def" || read[1].Title != nil {
+			t.Errorf("gzipped %v: got documents %+v", gzipped, read)
+		}
+
+		for _, expected := range []string{"d1", "d2"} {
+			digest, content, size, err := ar.NextContent()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadAll(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if digest != expected || string(data) != contents[expected] || size != int64(len(data)) {
+				t.Errorf("gzipped %v: got %s %q (%d), expected %s %q", gzipped, digest, data, size, expected, contents[expected])
+			}
+		}
+
+		if _, _, _, err := ar.NextContent(); err != io.EOF {
+			t.Errorf("gzipped %v: got %v past the last content, expected %v", gzipped, err, io.EOF)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := map[string]string{
+		"other format": `{"format":"other","version":1}`,
+		"later":        `{"format":"nekobin","version":2}`,
+		"no version":   `{"format":"nekobin"}`,
+		"not JSON":     `nekobin`,
+	}
+
+	for name, manifest := range tests {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+
+		if err := tw.WriteHeader(&tar.Header{Name: manifestName, Size: int64(len(manifest)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(manifest)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewReader(buf); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: got %v, expected %v", name, err, ErrUnsupported)
+		}
+	}
+
+	// Anything but a manifest first
+	buf := &bytes.Buffer{}
+	ar := NewWriter(buf)
+
+	if err := ar.WriteDocuments(strings.NewReader("{}\n"), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ar.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReader(buf); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("no manifest: got %v, expected %v", err, ErrUnsupported)
+	}
+}
@@ -35,6 +35,13 @@ class Nekobin {
 
     CodeMirror.modeURL = "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.48.4/mode/%N/%N.min.js"
 
+    // The server renders documents for browsers without JavaScript: replace it with the editor
+    let prerendered = document.getElementById("document")
+
+    if (prerendered !== null) {
+      prerendered.remove()
+    }
+
     this.editor = CodeMirror(document.getElementById("content"), {
       placeholder: "Paste code, save and share the link!",
       lineNumbers: true
@@ -179,6 +186,30 @@ class Nekobin {
     })
   }
 
+  // Highlight and scroll to the lines in the URL fragment, such as #L120-L180
+  highlightLines() {
+    for (let line of this.highlightedLines || []) {
+      this.editor.removeLineClass(line, "background", "highlighted-line")
+    }
+
+    this.highlightedLines = []
+
+    let range = parseLineHash(window.location.hash)
+
+    if (range === undefined) {
+      return
+    }
+
+    let [first, last] = range
+    last = Math.min(last, this.editor.lineCount())
+
+    for (let line = first - 1; line < last; line++) {
+      this.highlightedLines.push(this.editor.addLineClass(line, "background", "highlighted-line"))
+    }
+
+    this.editor.scrollIntoView({line: first - 1, ch: 0}, 100)
+  }
+
   async load() {
     let path = window.location.pathname
 
@@ -186,28 +217,46 @@ class Nekobin {
       return
     }
 
-    let response = await fetch(`/api/documents${path}`)
+    // The server embeds the document in the page, there is none if it was not found
+    let data = document.getElementById("document-data")
 
-    if (response.ok) {
-      let {key, content} = (await response.json()).result
+    if (data !== null) {
+      let {key, content, language, external} = JSON.parse(data.textContent)
+
+      // Too large for the editor, only served raw
+      if (external) {
+        window.location.replace(`/raw${path}`)
+        return
+      }
 
       this.editor.getDoc().setValue(content)
       this.editor.setOption("readOnly", true)
 
-      let mode = CodeMirror.findModeByFileName(path)
+      // Prefer the stored language so links without an extension are highlighted too
+      let mode = (language && CodeMirror.findModeByName(language)) || CodeMirror.findModeByFileName(path)
 
       if (mode !== undefined) {
         CodeMirror.autoLoadMode(this.editor, mode.mode)
         this.editor.setOption("mode", mode.mime)
       }
 
-      document.getElementById("content").classList.add("readonly")
-      document.title = `nekobin - ${key}`
+      this.highlightLines()
+      window.addEventListener("hashchange", () => this.highlightLines())
 
-      let url = document.getElementById("url")
+      // Click a line number to link to it, shift-click to link to a range
+      this.editor.on("gutterClick", (cm, line, gutter, event) => {
+        let first = line + 1
+        let [start] = parseLineHash(window.location.hash) || []
+
+        if (event.shiftKey && start !== undefined) {
+          window.location.hash = `#L${Math.min(start, first)}-L${Math.max(start, first)}`
+        } else {
+          window.location.hash = `#L${first}`
+        }
+      })
 
-      url.insertAdjacentText("afterbegin", path)
-      url.classList.remove("hidden")
+      document.getElementById("content").classList.add("readonly")
+      document.title = `nekobin - ${key}`
 
       this.actions.save.disabled = true
 
@@ -215,16 +264,25 @@ class Nekobin {
         this.actions.raw.disabled = false
       }
     } else {
-      if (response.status === 429) {
-        let {error} = await response.json()
-        alert(`Error: ${error}`)
-      } else {
-        window.location.replace("/")
-      }
+      window.location.replace("/")
     }
   }
 }
 
+// "#L120-L180" to [120, 180], "#L120" to [120, 120]
+function parseLineHash(hash) {
+  let match = /^#L(\d+)(?:-L?(\d+))?$/.exec(hash)
+
+  if (match === null) {
+    return undefined
+  }
+
+  let first = parseInt(match[1])
+  let last = match[2] === undefined ? first : parseInt(match[2])
+
+  return first > 0 && last >= first ? [first, last] : undefined
+}
+
 // https://www.w3schools.com/js/js_cookies.asp
 function getCookie(cname) {
   let name = cname + "="
@@ -0,0 +1,271 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/storage"
+)
+
+var errNotFound = errors.New("document not found")
+
+// Open the database along with the blob store, without the background work
+// of the server
+func openDatabase(cfg *config.Config) (*database.Database, error) {
+	store, err := storage.NewStore(&cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.Open(&cfg.Database, store, cfg.Storage.Threshold)
+}
+
+// Parse the flags of a command, which come before its arguments
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	flags.SetOutput(ioutil.Discard)
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	return nil
+}
+
+// Print the content of a document, or the document itself as JSON
+func get(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+
+	if err := parseFlags(flags, args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+
+	doc, err := db.Documents.Select(ctx, flags.Arg(0))
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(doc)
+	}
+
+	content, err := db.Documents.Open(ctx, doc)
+	if err != nil {
+		return err
+	}
+
+	defer content.Close()
+
+	_, err = io.Copy(os.Stdout, content)
+
+	return err
+}
+
+func deleteDocument(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	err = db.Documents.Delete(context.Background(), args[0])
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("deleted", args[0])
+
+	return sweep(db)
+}
+
+// Delete documents older than an age, one by one so that their contents are
+// released like any other deletion.
+func purge(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	olderThan := flags.String("older-than", "", "")
+	dryRun := flags.Bool("dry-run", false, "")
+
+	if err := parseFlags(flags, args); err != nil || flags.NArg() != 0 || *olderThan == "" {
+		return errUsage
+	}
+
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+
+	keys, err := db.Documents.Keys(ctx, time.Now().Add(-age))
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+
+	for _, key := range keys {
+		if *dryRun {
+			fmt.Println(key)
+			continue
+		}
+
+		// Deleted by someone else meanwhile
+		if err := db.Documents.Delete(ctx, key); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("deleting %s: %w", key, err)
+		}
+
+		deleted++
+	}
+
+	if *dryRun {
+		fmt.Printf("%d document(s) would be deleted\n", len(keys))
+		return nil
+	}
+
+	fmt.Printf("%d document(s) deleted\n", deleted)
+
+	return sweep(db)
+}
+
+// Delete the contents left in the blob store by deletions
+func sweep(db *database.Database) error {
+	count, err := db.Documents.Sweep(context.Background())
+	if err != nil {
+		return fmt.Errorf("sweeping orphan blobs: %w", err)
+	}
+
+	if count > 0 {
+		fmt.Printf("%d orphan blob(s) deleted\n", count)
+	}
+
+	return nil
+}
+
+// Parse an age such as "90m", "720h" or "30d"
+func parseAge(s string) (time.Duration, error) {
+	var age time.Duration
+	var err error
+
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		var n int
+		n, err = strconv.Atoi(days)
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		age, err = time.ParseDuration(s)
+	}
+
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid age: %s", s)
+	}
+
+	return age, nil
+}
+
+func stats(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	s, err := db.Stats(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "documents\t%d\n", s.Documents)
+	fmt.Fprintf(w, "views\t%d\n", s.Views)
+	fmt.Fprintf(w, "contents\t%d\t%d bytes\n", s.Blobs, s.Bytes)
+	fmt.Fprintf(w, "external contents\t%d\t%d bytes\n", s.ExternalBlobs, s.ExternalBytes)
+	fmt.Fprintf(w, "uploads in progress\t%d\n", s.Uploads)
+
+	return w.Flush()
+}
+
+// The configuration is checked by loading it, which fails on errors. The
+// blob store is set up too, as its settings are only checked then.
+func checkConfig(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	if _, err := storage.NewStore(&cfg.Storage); err != nil {
+		return err
+	}
+
+	fmt.Println("configuration ok")
+
+	return nil
+}
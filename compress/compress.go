@@ -0,0 +1,186 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Brotli = "br"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+)
+
+// Supported encodings, in order of preference.
+var Encodings = []string{Brotli, Zstd, Gzip}
+
+var ErrUnsupported = errors.New("unsupported encoding")
+
+func IsSupported(encoding string) bool {
+	for _, e := range Encodings {
+		if e == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Negotiate picks the best of the offered encodings according to an
+// Accept-Encoding header. An empty string means no encoding (identity).
+func Negotiate(acceptEncoding string, offers ...string) string {
+	qualities := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		if name == "" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		qualities[name] = quality
+	}
+
+	best, bestQuality := "", 0.0
+
+	for _, offer := range offers {
+		quality, ok := qualities[offer]
+
+		if !ok {
+			quality = qualities["*"]
+		}
+
+		// Ties are won by the earliest offer
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+
+	return best
+}
+
+// ETag turns the entity tag of an identity response into the one of its
+// encoded variant, as they must differ.
+func ETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// StripETags undoes ETag on the entity tags of a request header such as
+// If-None-Match, whichever encoding they were for.
+func StripETags(etags string) string {
+	for _, encoding := range Encodings {
+		etags = strings.ReplaceAll(etags, "-"+encoding+`"`, `"`)
+	}
+
+	return etags
+}
+
+func NewWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case Brotli:
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	}
+
+	return nil, ErrUnsupported
+}
+
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case Brotli:
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	}
+
+	return nil, ErrUnsupported
+}
+
+func Encode(encoding string, data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	w, err := NewWriter(encoding, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func Decode(encoding string, data []byte) ([]byte, error) {
+	r, err := NewReader(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,59 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compress
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		offers         []string
+		expected       string
+	}{
+		{"", Encodings, ""},
+		{"gzip", Encodings, Gzip},
+		{"gzip, deflate, br", Encodings, Brotli},
+		{"gzip, deflate, br, zstd", Encodings, Brotli},
+		{"GZIP", Encodings, Gzip},
+		{" gzip ; q=0.5 , zstd;q=0.8", Encodings, Zstd},
+		{"br;q=0, gzip", Encodings, Gzip},
+		{"*", Encodings, Brotli},
+		{"*;q=0.1, gzip;q=0.5", Encodings, Gzip},
+		{"*, br;q=0", Encodings, Zstd},
+		{"*;q=0", Encodings, ""},
+		{"identity", Encodings, ""},
+		{"deflate", Encodings, ""},
+		{"gzip;q=invalid", Encodings, Gzip},
+		{"gzip", []string{Brotli}, ""},
+		{"br, gzip", []string{Gzip}, Gzip},
+		{"br, gzip", nil, ""},
+	}
+
+	for _, test := range tests {
+		if encoding := Negotiate(test.acceptEncoding, test.offers...); encoding != test.expected {
+			t.Errorf("%q %v: got %q, expected %q", test.acceptEncoding, test.offers, encoding, test.expected)
+		}
+	}
+}
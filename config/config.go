@@ -26,12 +26,13 @@ package config
 
 import (
 	"io/ioutil"
-	"log"
 	"time"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/nekobin/nekobin/compress"
 	"github.com/nekobin/nekobin/limiter"
+	"github.com/nekobin/nekobin/logger"
 )
 
 type (
@@ -42,14 +43,102 @@ type (
 		MaxTitleLength   int `yaml:"max_title_length"`
 		MaxAuthorLength  int `yaml:"max_author_length"`
 		MaxContentLength int `yaml:"max_content_length"`
+
+		LogLevel logger.Level `yaml:"log_level"`
+
+		ShutdownDelay   time.Duration `yaml:"shutdown_delay"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 	}
 
 	Database struct {
 		URI string `yaml:"uri"`
 
+		// Apply pending migrations at startup
+		AutoMigrate bool `yaml:"auto_migrate"`
+
 		MaxIdleConns    int           `yaml:"max_idle_conns"`
 		MaxOpenConns    int           `yaml:"max_open_conns"`
 		ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+
+		Compression          string `yaml:"compression"`
+		CompressionMinLength int    `yaml:"compression_min_length"`
+
+		CacheSize int64 `yaml:"cache_size"`
+
+		ViewsFlushInterval time.Duration `yaml:"views_flush_interval"`
+		ViewsSecret        string        `yaml:"views_secret"`
+
+		Timeouts Timeouts `yaml:"timeouts"`
+	}
+
+	// Seconds database operations may take
+	Timeouts struct {
+		Read  time.Duration `yaml:"read"`
+		Write time.Duration `yaml:"write"`
+		Views time.Duration `yaml:"views"`
+	}
+
+	FS struct {
+		Path string `yaml:"path"`
+	}
+
+	S3 struct {
+		Endpoint  string `yaml:"endpoint"`
+		Bucket    string `yaml:"bucket"`
+		Region    string `yaml:"region"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+		UseSSL    bool   `yaml:"use_ssl"`
+	}
+
+	Storage struct {
+		Backend         string `yaml:"backend"`
+		Threshold       int64  `yaml:"threshold"`
+		MaxDocumentSize int64  `yaml:"max_document_size"`
+		TempPath        string `yaml:"temp_path"`
+		FS              FS     `yaml:"fs"`
+		S3              S3     `yaml:"s3"`
+	}
+
+	Uploads struct {
+		MaxSize    int64         `yaml:"max_size"`
+		Expiration time.Duration `yaml:"expiration"`
+	}
+
+	Metrics struct {
+		// Address of a separate listener for /metrics. When empty, /metrics is
+		// served along the rest, provided a token is set.
+		Listen string `yaml:"listen"`
+		Token  string `yaml:"token"`
+	}
+
+	Tracing struct {
+		// "otlp", "stdout", "file" or empty to disable
+		Exporter    string  `yaml:"exporter"`
+		Endpoint    string  `yaml:"endpoint"`
+		Insecure    bool    `yaml:"insecure"`
+		Path        string  `yaml:"path"`
+		SampleRatio float64 `yaml:"sample_ratio"`
+		ServiceName string  `yaml:"service_name"`
+	}
+
+	Health struct {
+		// Seconds each readiness check may take
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	Embed struct {
+		FrameAncestors []string `yaml:"frame_ancestors"`
+	}
+
+	Image struct {
+		MaxLines   int `yaml:"max_lines"`
+		MaxColumns int `yaml:"max_columns"`
+	}
+
+	Cache struct {
+		Documents string `yaml:"documents"`
+		API       string `yaml:"api"`
 	}
 
 	Documents struct {
@@ -58,12 +147,21 @@ type (
 	}
 
 	Limits struct {
-		Documents Documents `yaml:"documents"`
+		Documents Documents       `yaml:"documents"`
+		Uploads   []limiter.Limit `yaml:"uploads"`
 	}
 
 	Config struct {
 		Nekobin  Nekobin  `yaml:"nekobin"`
 		Database Database `yaml:"database"`
+		Storage  Storage  `yaml:"storage"`
+		Uploads  Uploads  `yaml:"uploads"`
+		Metrics  Metrics  `yaml:"metrics"`
+		Health   Health   `yaml:"health"`
+		Tracing  Tracing  `yaml:"tracing"`
+		Embed    Embed    `yaml:"embed"`
+		Image    Image    `yaml:"image"`
+		Cache    Cache    `yaml:"cache"`
 		Limits   Limits   `yaml:"limits"`
 	}
 )
@@ -71,14 +169,14 @@ type (
 func Load(path string) *Config {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("reading the configuration", "path", path, "error", err)
 	}
 
 	cfg := &Config{}
 
 	err = yaml.UnmarshalStrict(file, cfg)
 	if err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("parsing the configuration", "path", path, "error", err)
 	}
 
 	// YAML time values are kept in seconds for convenience.
@@ -91,6 +189,108 @@ func Load(path string) *Config {
 		for i, post := 0, cfg.Limits.Documents.Post; i < len(post); i++ {
 			post[i].Period *= time.Second
 		}
+
+		for i, uploads := 0, cfg.Limits.Uploads; i < len(uploads); i++ {
+			uploads[i].Period *= time.Second
+		}
+
+		cfg.Uploads.Expiration *= time.Second
+		cfg.Database.ViewsFlushInterval *= time.Second
+		cfg.Database.Timeouts.Read *= time.Second
+		cfg.Database.Timeouts.Write *= time.Second
+		cfg.Database.Timeouts.Views *= time.Second
+		cfg.Health.Timeout *= time.Second
+		cfg.Nekobin.ShutdownDelay *= time.Second
+		cfg.Nekobin.ShutdownTimeout *= time.Second
+	}
+
+	if c := cfg.Database.Compression; c != "" && !compress.IsSupported(c) {
+		logger.Default.Fatal("unsupported database compression", "compression", c)
+	}
+
+	// Without a secret, hashed IP addresses could be reversed by trying them all
+	if cfg.Database.ViewsSecret == "" {
+		logger.Default.Fatal("database.views_secret must be set", "path", path)
+	}
+
+	if cfg.Database.Timeouts.Read <= 0 {
+		cfg.Database.Timeouts.Read = 5 * time.Second
+	}
+
+	if cfg.Database.Timeouts.Write <= 0 {
+		cfg.Database.Timeouts.Write = 10 * time.Second
+	}
+
+	if cfg.Database.Timeouts.Views <= 0 {
+		cfg.Database.Timeouts.Views = 30 * time.Second
+	}
+
+	if cfg.Database.ViewsFlushInterval <= 0 {
+		cfg.Database.ViewsFlushInterval = 10 * time.Second
+	}
+
+	if cfg.Nekobin.ShutdownTimeout <= 0 {
+		cfg.Nekobin.ShutdownTimeout = 30 * time.Second
+	}
+
+	if cfg.Tracing.ServiceName == "" {
+		cfg.Tracing.ServiceName = "nekobin"
+	}
+
+	// Every trace is recorded unless configured otherwise.
+	if cfg.Tracing.SampleRatio <= 0 {
+		cfg.Tracing.SampleRatio = 1
+	}
+
+	if cfg.Health.Timeout <= 0 {
+		cfg.Health.Timeout = 2 * time.Second
+	}
+
+	// Raw uploads are limited like any other, unless configured otherwise.
+	if cfg.Storage.MaxDocumentSize <= 0 {
+		cfg.Storage.MaxDocumentSize = int64(cfg.Nekobin.MaxContentLength)
+	}
+
+	if cfg.Uploads.MaxSize <= 0 {
+		cfg.Uploads.MaxSize = cfg.Storage.MaxDocumentSize
+	}
+
+	// Without a blob store every document is kept in the database, which means
+	// reading it in memory: they are capped to the threshold then.
+	if cfg.Storage.Backend == "" {
+		limit := cfg.Storage.Threshold
+
+		if limit <= 0 {
+			limit = int64(cfg.Nekobin.MaxContentLength)
+		}
+
+		if cfg.Storage.MaxDocumentSize > limit {
+			logger.Default.Warn("documents capped to the threshold without a blob store", "max_document_size", limit)
+			cfg.Storage.MaxDocumentSize = limit
+		}
+
+		if cfg.Uploads.MaxSize > limit {
+			cfg.Uploads.MaxSize = limit
+		}
+	}
+
+	// Partial uploads are kept for a day since their last chunk by default.
+	if cfg.Uploads.Expiration <= 0 {
+		cfg.Uploads.Expiration = 24 * time.Hour
+	}
+
+	// Documents never change once saved, their contents can be cached for good.
+	if cfg.Cache.Documents == "" {
+		cfg.Cache.Documents = "public, max-age=31536000, immutable"
+	}
+
+	// Images are always capped, in case the limits are not configured.
+	if cfg.Image.MaxLines <= 0 {
+		cfg.Image.MaxLines = 200
+	}
+
+	if cfg.Image.MaxColumns <= 0 {
+		cfg.Image.MaxColumns = 160
 	}
 
 	return cfg
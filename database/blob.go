@@ -0,0 +1,278 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/compress"
+	"github.com/nekobin/nekobin/storage"
+)
+
+// Document contents, stored once no matter how many documents share them.
+// They are keyed by their SHA-256 digest and reference counted.
+type blobs struct {
+	// Compression of newly stored contents
+	encoding  string
+	minLength int
+
+	// Contents larger than threshold are kept in store, if any, instead.
+	// Such blobs are flagged as external.
+	store     storage.Store
+	threshold int64
+}
+
+// ErrNotText is returned for contents to be kept in the database which aren't text.
+var ErrNotText = errors.New("content is not text")
+
+// IsText reports whether content can be kept in the database, as text: it must
+// be valid UTF-8, without NUL characters.
+func IsText(content string) bool {
+	return utf8.ValidString(content) && !strings.ContainsRune(content, 0)
+}
+
+func Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash the contents of documents stored compressed before blobs existed,
+// which Postgres can't decompress. It's part of the migration to blobs and
+// goes through them in batches, to hold few of them in memory at once.
+func digestCompressed(ctx context.Context, tx *sql.Tx) error {
+	for {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT key, data, encoding
+			FROM documents
+			WHERE digest IS NULL
+			ORDER BY key
+			LIMIT 100`,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		digests := make(map[string]string)
+
+		for rows.Next() {
+			var key, encoding string
+			var data []byte
+
+			if err := rows.Scan(&key, &data, &encoding); err != nil {
+				rows.Close()
+				return err
+			}
+
+			content, err := compress.Decode(encoding, data)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("document %s: %w", key, err)
+			}
+
+			digests[key] = Digest(string(content))
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(digests) == 0 {
+			return nil
+		}
+
+		for key, digest := range digests {
+			if _, err := tx.ExecContext(ctx, "UPDATE documents SET digest = $1 WHERE key = $2", digest, key); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Take a reference to the blob with digest, if it exists.
+func (b *blobs) reference(ctx context.Context, tx *sqlx.Tx, digest string) (exists bool, err error) {
+	result, err := tx.ExecContext(ctx, "UPDATE blobs SET refcount = refcount + 1 WHERE digest = $1", digest)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return n > 0, err
+}
+
+// Whether contents of size are kept in the store
+func (b *blobs) isExternal(size int64) bool {
+	return b.store != nil && size > b.threshold
+}
+
+// Put an external content in the store. Its blob must have been reserved
+// beforehand, so that it can't be swept meanwhile.
+func (b *blobs) put(ctx context.Context, digest string, r io.Reader, size int64) error {
+	return b.store.Put(ctx, digest, r, size)
+}
+
+// Take a reference to the blob of content, storing it if it's new, in which
+// case it fails with ErrNotText unless it's text. Contents to be kept in the
+// store are reserved instead.
+func (b *blobs) acquire(ctx context.Context, tx *sqlx.Tx, digest, content string) (err error) {
+	if exists, err := b.reference(ctx, tx, digest); err != nil || exists {
+		return err
+	}
+
+	if !IsText(content) {
+		return ErrNotText
+	}
+
+	stored := &content
+	var data []byte
+	var encoding *string
+
+	if b.encoding != "" && len(content) >= b.minLength {
+		data, err = compress.Encode(b.encoding, []byte(content))
+		if err != nil {
+			return err
+		}
+
+		// Not worth it when it doesn't actually shrink
+		if len(data) < len(content) {
+			stored, encoding = nil, &b.encoding
+		} else {
+			data = nil
+		}
+	}
+
+	// Someone else may have stored the same content in the meantime
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO blobs (digest, refcount, length, content, data, encoding)
+		VALUES ($1, 1, $2, $3, $4, $5)
+		ON CONFLICT (digest) DO UPDATE SET refcount = blobs.refcount + 1`,
+		digest, len(content), stored, data, encoding,
+	)
+
+	return err
+}
+
+// Take a reference to the blob of a spooled content, storing it if it's new.
+// It's read in memory: it's no larger than the threshold, or than the maximum
+// size of documents, capped to it when there's no store.
+func (b *blobs) acquireSpool(ctx context.Context, tx *sqlx.Tx, spool *storage.Spool) error {
+	content, err := ioutil.ReadAll(spool)
+	if err != nil {
+		return err
+	}
+
+	return b.acquire(ctx, tx, spool.Digest, string(content))
+}
+
+// Take a reference to the blob of an external content ahead of putting it in
+// the store, creating the blob if it's new. The reference is to be released
+// should no document end up with it. It may turn out that the same content
+// is already kept in the database, external is false then.
+func (b *blobs) reserve(ctx context.Context, tx *sqlx.Tx, digest string, size int64) (external bool, err error) {
+	err = tx.QueryRowxContext(
+		ctx,
+		`INSERT INTO blobs (digest, refcount, length, external)
+		VALUES ($1, 1, $2, TRUE)
+		ON CONFLICT (digest) DO UPDATE SET refcount = blobs.refcount + 1
+		RETURNING external`,
+		digest, size,
+	).Scan(&external)
+
+	return
+}
+
+// Drop a reference to a blob. Blobs kept in the database are deleted along
+// with their last reference, external ones are left to be swept: their
+// content may be put in the store again by now.
+func (b *blobs) release(ctx context.Context, tx *sqlx.Tx, digest string) error {
+	var refcount int
+	var external bool
+
+	err := tx.QueryRowxContext(
+		ctx,
+		"UPDATE blobs SET refcount = refcount - 1 WHERE digest = $1 RETURNING refcount, external",
+		digest,
+	).Scan(&refcount, &external)
+
+	if err != nil || refcount > 0 || external {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM blobs WHERE digest = $1 AND refcount <= 0", digest)
+
+	return err
+}
+
+// Delete an orphan external blob, if any, content first. The blob is locked
+// meanwhile so that no one can take a reference to it; orphans locked by
+// someone else are skipped. found is false if there was none.
+func (b *blobs) sweep(ctx context.Context, tx *sqlx.Tx) (found bool, err error) {
+	var digest string
+
+	err = tx.QueryRowxContext(
+		ctx,
+		"SELECT digest FROM blobs WHERE refcount <= 0 AND external LIMIT 1 FOR UPDATE SKIP LOCKED",
+	).Scan(&digest)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := b.store.Delete(ctx, digest); err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM blobs WHERE digest = $1", digest)
+
+	return err == nil, err
+}
+
+// Open the content of a blob kept in the store.
+func (b *blobs) open(ctx context.Context, digest string) (io.ReadCloser, error) {
+	if b.store == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	return b.store.Get(ctx, digest)
+}
@@ -0,0 +1,215 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/storage"
+)
+
+func TestIsText(t *testing.T) {
+	tests := map[string]bool{
+		"":                true,
+		"plain":           true,
+		"ünïcødé 🐈":       true,
+		"tab\tand\nlines": true,
+		"nul\x00":         false,
+		"latin1 \xe9":     false,
+		"\xff\xfe":        false,
+	}
+
+	for content, expected := range tests {
+		if text := IsText(content); text != expected {
+			t.Errorf("%q: got %v, expected %v", content, text, expected)
+		}
+	}
+}
+
+type blobRow struct {
+	Refcount int
+	External bool
+}
+
+// The blob with digest, nil if there's none
+func selectBlob(t *testing.T, db *sqlx.DB, digest string) *blobRow {
+	blob := &blobRow{}
+
+	err := db.Get(blob, "SELECT refcount, external FROM blobs WHERE digest = $1", digest)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return blob
+}
+
+func TestBlobsShared(t *testing.T) {
+	db := testDB(t)
+	docs := NewDocuments(db, &config.Database{Timeouts: testTimeouts}, nil, 0, nil)
+	ctx := context.Background()
+
+	first, err := docs.Insert(ctx, nil, nil, nil, 0, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := docs.Insert(ctx, nil, nil, nil, 0, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Digest != second.Digest {
+		t.Fatalf("same content, digests %s and %s", first.Digest, second.Digest)
+	}
+
+	if blob := selectBlob(t, db, first.Digest); blob == nil || blob.Refcount != 2 {
+		t.Fatalf("got blob %+v, expected 2 references", blob)
+	}
+
+	if err := docs.Delete(ctx, first.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	if blob := selectBlob(t, db, first.Digest); blob == nil || blob.Refcount != 1 {
+		t.Fatalf("got blob %+v, expected 1 reference", blob)
+	}
+
+	doc, err := docs.Select(ctx, second.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Content != "shared" {
+		t.Errorf("got %q, expected %q", doc.Content, "shared")
+	}
+
+	if err := docs.Delete(ctx, second.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept in the database, gone with its last reference
+	if blob := selectBlob(t, db, first.Digest); blob != nil {
+		t.Errorf("got blob %+v, expected none", blob)
+	}
+
+	// Nothing to sweep without a store
+	if count, err := docs.Sweep(ctx); err != nil || count != 0 {
+		t.Errorf("swept %d, %v", count, err)
+	}
+}
+
+func TestBlobsSweep(t *testing.T) {
+	db := testDB(t)
+
+	store, err := storage.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := NewDocuments(db, &config.Database{Timeouts: testTimeouts}, store, 4, nil)
+	ctx := context.Background()
+	content := "larger than the threshold"
+
+	first, err := docs.Insert(ctx, nil, nil, nil, 0, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := docs.Insert(ctx, nil, nil, nil, 0, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob := selectBlob(t, db, first.Digest); blob == nil || blob.Refcount != 2 || !blob.External {
+		t.Fatalf("got blob %+v, expected 2 references to an external one", blob)
+	}
+
+	if err := docs.Delete(ctx, first.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := docs.Sweep(ctx); err != nil || count != 0 {
+		t.Fatalf("swept %d, %v, expected nothing while referenced", count, err)
+	}
+
+	r, err := docs.Open(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ioutil.ReadAll(r)
+	r.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(read) != content {
+		t.Errorf("got %q, expected %q", read, content)
+	}
+
+	if err := docs.Delete(ctx, second.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Orphans are left behind until swept
+	if blob := selectBlob(t, db, first.Digest); blob == nil || blob.Refcount != 0 {
+		t.Fatalf("got blob %+v, expected an orphan", blob)
+	}
+
+	if count, err := docs.Sweep(ctx); err != nil || count != 1 {
+		t.Fatalf("swept %d, %v, expected 1", count, err)
+	}
+
+	if blob := selectBlob(t, db, first.Digest); blob != nil {
+		t.Errorf("got blob %+v, expected none", blob)
+	}
+
+	if _, err := store.Get(ctx, first.Digest); err != storage.ErrNotFound {
+		t.Errorf("got %v, expected %v", err, storage.ErrNotFound)
+	}
+
+	// Put in the store again once referenced again
+	if _, err := docs.Insert(ctx, nil, nil, nil, 0, content); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = store.Get(ctx, first.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Close()
+}
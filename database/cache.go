@@ -0,0 +1,253 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rough size of a document besides its content, for cache accounting
+const documentOverhead = 256
+
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+	MaxSize int64  `json:"max_size"`
+}
+
+// DocumentsCache is a read-through LRU cache in front of DocumentsQuery.Select,
+// holding up to maxSize bytes of documents. Concurrent misses for the same key
+// share a single query.
+type DocumentsCache struct {
+	DocumentsQuery
+
+	maxSize int64
+	timeout time.Duration
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+	calls   map[string]*cacheCall
+	mu      *sync.Mutex
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	doc  *Document
+	size int64
+}
+
+// A query in flight, whose result is shared by every caller
+type cacheCall struct {
+	wg  sync.WaitGroup
+	doc *Document
+	err error
+	// Invalidated while in flight, its result must not be cached
+	stale bool
+}
+
+func NewDocumentsCache(docs DocumentsQuery, maxSize int64, timeout time.Duration) *DocumentsCache {
+	return &DocumentsCache{
+		DocumentsQuery: docs,
+		maxSize:        maxSize,
+		timeout:        timeout,
+		entries:        make(map[string]*list.Element),
+		lru:            list.New(),
+		calls:          make(map[string]*cacheCall),
+		mu:             &sync.Mutex{},
+	}
+}
+
+func (cache *DocumentsCache) Select(ctx context.Context, key string) (doc *Document, err error) {
+	cache.mu.Lock()
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.lru.MoveToFront(elem)
+		doc = elem.Value.(*cacheEntry).doc.copy()
+		cache.mu.Unlock()
+
+		atomic.AddUint64(&cache.hits, 1)
+
+		return doc, nil
+	}
+
+	atomic.AddUint64(&cache.misses, 1)
+
+	if call, ok := cache.calls[key]; ok {
+		cache.mu.Unlock()
+		call.wg.Wait()
+
+		if call.err != nil {
+			return nil, call.err
+		}
+
+		return call.doc.copy(), nil
+	}
+
+	call := &cacheCall{}
+	call.wg.Add(1)
+	cache.calls[key] = call
+	cache.mu.Unlock()
+
+	// Other callers wait for this query too: it mustn't be cancelled with ctx,
+	// only bounded by its own deadline
+	queryCtx, cancel := context.WithTimeout(detach(ctx), cache.timeout)
+	call.doc, call.err = cache.DocumentsQuery.Select(queryCtx, key)
+	cancel()
+
+	cache.mu.Lock()
+	delete(cache.calls, key)
+
+	if call.err == nil && !call.stale {
+		cache.add(key, call.doc)
+	}
+
+	cache.mu.Unlock()
+	call.wg.Done()
+
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return call.doc.copy(), nil
+}
+
+func (cache *DocumentsCache) Delete(ctx context.Context, key string) (err error) {
+	err = cache.DocumentsQuery.Delete(ctx, key)
+	cache.Invalidate(key)
+
+	return
+}
+
+// AddViews counts views in the cached document too, so that it stays current.
+func (cache *DocumentsCache) AddViews(key string, count int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value.(*cacheEntry).doc.Views += count
+	}
+}
+
+// Invalidate drops a document from the cache, to be called whenever it changes.
+func (cache *DocumentsCache) Invalidate(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.remove(elem)
+	}
+
+	if call, ok := cache.calls[key]; ok {
+		call.stale = true
+	}
+}
+
+// Clear drops every document from the cache.
+func (cache *DocumentsCache) Clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, elem := range cache.entries {
+		cache.remove(elem)
+	}
+
+	for _, call := range cache.calls {
+		call.stale = true
+	}
+}
+
+func (cache *DocumentsCache) Stats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&cache.hits),
+		Misses:  atomic.LoadUint64(&cache.misses),
+		Entries: cache.lru.Len(),
+		Size:    cache.size,
+		MaxSize: cache.maxSize,
+	}
+}
+
+// Add a document, evicting the least recently used ones to make room.
+// Documents too large for the cache are left out.
+func (cache *DocumentsCache) add(key string, doc *Document) {
+	size := int64(len(doc.Content)+len(doc.Data)) + documentOverhead
+
+	if size > cache.maxSize {
+		return
+	}
+
+	for cache.size+size > cache.maxSize {
+		cache.remove(cache.lru.Back())
+	}
+
+	cache.entries[key] = cache.lru.PushFront(&cacheEntry{doc: doc.copy(), size: size})
+	cache.size += size
+}
+
+func (cache *DocumentsCache) remove(elem *list.Element) {
+	entry := cache.lru.Remove(elem).(*cacheEntry)
+
+	delete(cache.entries, entry.doc.Key)
+	cache.size -= entry.size
+}
+
+// Cached documents are shared: callers get their own copy to work with.
+// Contents are immutable, so they aren't copied.
+func (doc *Document) copy() *Document {
+	c := *doc
+	return &c
+}
+
+// A context with the values of its parent, such as the trace, but not its
+// cancellation nor deadline.
+type detached struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
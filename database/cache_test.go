@@ -0,0 +1,288 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Documents in memory. Select blocks until release is closed, when set.
+type fakeDocuments struct {
+	DocumentsQuery
+
+	docs    map[string]string
+	queries int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newFakeDocuments(docs map[string]string) *fakeDocuments {
+	return &fakeDocuments{docs: docs, started: make(chan struct{}, 16)}
+}
+
+func (fake *fakeDocuments) Select(ctx context.Context, key string) (*Document, error) {
+	atomic.AddInt32(&fake.queries, 1)
+	fake.started <- struct{}{}
+
+	if fake.release != nil {
+		select {
+		case <-fake.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	content, ok := fake.docs[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+
+	return &Document{Key: key, Content: content, Length: len(content)}, nil
+}
+
+// Size of a cached document with content of the given length
+func cachedSize(length int) int64 {
+	return int64(length) + documentOverhead
+}
+
+func TestCacheAccounting(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{"a": "hello", "b": "world!"})
+	cache := NewDocumentsCache(fake, 1<<20, time.Second)
+
+	for _, key := range []string{"a", "b", "a", "b", "a"} {
+		doc, err := cache.Select(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.Content != fake.docs[key] {
+			t.Fatalf("Select(%q) = %q", key, doc.Content)
+		}
+	}
+
+	stats := cache.Stats()
+
+	if stats.Hits != 3 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v, want 3 hits, 2 misses and 2 entries", stats)
+	}
+
+	if want := cachedSize(5) + cachedSize(6); stats.Size != want {
+		t.Errorf("Size = %d, want %d", stats.Size, want)
+	}
+
+	cache.Invalidate("a")
+
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Size != cachedSize(6) {
+		t.Errorf("after Invalidate, Stats() = %+v", stats)
+	}
+
+	cache.Clear()
+
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Size != 0 {
+		t.Errorf("after Clear, Stats() = %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{
+		"a":     "aaaa",
+		"b":     "bbbb",
+		"c":     "cccc",
+		"large": strings.Repeat("x", 1024),
+	})
+	// Room for two documents only
+	cache := NewDocumentsCache(fake, 2*cachedSize(4), time.Second)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if _, err := cache.Select(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b was the least recently used when c came in
+	queries := atomic.LoadInt32(&fake.queries)
+
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Select(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&fake.queries); got != queries {
+		t.Errorf("a and c were evicted, %d more queries", got-queries)
+	}
+
+	if _, err := cache.Select(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(&fake.queries); got != queries+1 {
+		t.Errorf("b wasn't evicted")
+	}
+
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Size != 2*cachedSize(4) {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	// Too large to be cached at all, nor to evict anything
+	if _, err := cache.Select(ctx, "large"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Size != 2*cachedSize(4) {
+		t.Errorf("after a large document, Stats() = %+v", stats)
+	}
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{"a": "hello"})
+	fake.release = make(chan struct{})
+	cache := NewDocumentsCache(fake, 1<<20, time.Second)
+
+	var wg sync.WaitGroup
+	docs := make([]*Document, 8)
+
+	for i := range docs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			doc, err := cache.Select(context.Background(), "a")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			docs[i] = doc
+		}(i)
+	}
+
+	<-fake.started
+	// Let the other callers join the query in flight
+	for cache.Stats().Misses < uint64(len(docs)) {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(fake.release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&fake.queries); got != 1 {
+		t.Errorf("%d queries, want 1", got)
+	}
+
+	// Callers get their own copy
+	docs[0].Views = 42
+
+	for _, doc := range docs[1:] {
+		if doc == nil || doc.Content != "hello" || doc.Views != 0 {
+			t.Errorf("got %+v", doc)
+		}
+	}
+}
+
+func TestCacheInvalidateInFlight(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{"a": "hello"})
+	fake.release = make(chan struct{})
+	cache := NewDocumentsCache(fake, 1<<20, time.Second)
+
+	done := make(chan error)
+
+	go func() {
+		_, err := cache.Select(context.Background(), "a")
+		done <- err
+	}()
+
+	<-fake.started
+	// The document changes while it's being read: the result may be outdated
+	cache.Invalidate("a")
+	close(fake.release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("stale result was cached: %+v", stats)
+	}
+
+	if _, err := cache.Select(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(&fake.queries); got != 2 {
+		t.Errorf("%d queries, want 2", got)
+	}
+}
+
+func TestCacheTimeout(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{"a": "hello"})
+	fake.release = make(chan struct{})
+	defer close(fake.release)
+	cache := NewDocumentsCache(fake, 1<<20, 10*time.Millisecond)
+
+	// Cancelling the caller's context doesn't cancel the shared query, but its
+	// own deadline does
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cache.Select(ctx, "a")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Select() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("failed query was cached: %+v", stats)
+	}
+}
+
+func TestCacheAddViews(t *testing.T) {
+	fake := newFakeDocuments(map[string]string{"a": "hello"})
+	cache := NewDocumentsCache(fake, 1<<20, time.Second)
+
+	if _, err := cache.Select(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.AddViews("a", 3)
+	// Not cached, nothing to do
+	cache.AddViews("b", 1)
+
+	doc, err := cache.Select(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Views != 3 {
+		t.Errorf("Views = %d, want 3", doc.Views)
+	}
+}
@@ -25,31 +25,121 @@
 package database
 
 import (
-	"log"
+	"context"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/logger"
+	"github.com/nekobin/nekobin/storage"
 )
 
 type Database struct {
 	Documents DocumentsQuery
+	Uploads   UploadsQuery
+	// Also in front of Documents, nil when disabled
+	Cache *DocumentsCache
+	Views *Views
+
+	db        *sqlx.DB
+	store     storage.Store
+	deletions *deletions
 }
 
-func NewDatabase(cfg *config.Database) *Database {
-	db := sqlx.MustConnect("postgres", cfg.URI)
+// Connect to the database server, with the pool configured.
+func Connect(cfg *config.Database) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("postgres", cfg.URI)
+	if err != nil {
+		return nil, err
+	}
 
 	db.SetMaxIdleConns(cfg.MaxIdleConns)
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
 	db.SetConnMaxLifetime(cfg.ConnMaxLifetime * time.Second)
 
-	err := db.Ping()
+	return db, nil
+}
+
+// Open the database without side effects: nothing runs in the background
+// and the schema is not touched, as admin commands need it. Documents are
+// not cached.
+func Open(cfg *config.Database, store storage.Store, threshold int64) (*Database, error) {
+	db, err := Connect(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
+	views := NewViews(db, cfg.ViewsSecret, cfg.Timeouts.Views)
+
 	return &Database{
-		Documents: NewDocuments(db),
+		Documents: NewDocuments(db, cfg, store, threshold, views),
+		Uploads:   NewUploads(db, cfg.Timeouts),
+		Views:     views,
+		db:        db,
+		store:     store,
+	}, nil
+}
+
+// NewDatabase opens the database for the server: pending migrations are
+// applied if enabled, views are flushed in the background, documents are
+// cached and metrics registered. Failing to do so is fatal.
+func NewDatabase(cfg *config.Database, store storage.Store, threshold int64) *Database {
+	database, err := Open(cfg, store, threshold)
+	if err != nil {
+		logger.Default.Fatal("connecting to the database", "error", err)
+	}
+
+	if cfg.AutoMigrate {
+		migrator, err := NewMigrator(database.db)
+		if err != nil {
+			logger.Default.Fatal("loading migrations", "error", err)
+		}
+
+		if _, err := migrator.Up(context.Background()); err != nil {
+			logger.Default.Fatal("migrating the database", "error", err)
+		}
+	}
+
+	database.Views.Start(cfg.ViewsFlushInterval)
+
+	if cfg.CacheSize > 0 {
+		database.Cache = NewDocumentsCache(database.Documents, cfg.CacheSize, cfg.Timeouts.Read)
+		database.Documents = database.Cache
+		database.Views.OnCounted = database.Cache.AddViews
+
+		database.deletions, err = listenDeletions(cfg.URI, database.Cache)
+		if err != nil {
+			logger.Default.Fatal("listening to deletions", "error", err)
+		}
+	}
+
+	registerMetrics(database.db, database.Views, database.Cache)
+
+	return database
+}
+
+// Ping the database server.
+func (db *Database) Ping(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+// Store is the blob store of large documents, nil if none is configured.
+func (db *Database) Store() storage.Store {
+	return db.store
+}
+
+// Close writes what's still pending, such as views, then closes the connections.
+func (db *Database) Close() error {
+	if err := db.Views.Close(); err != nil {
+		logger.Default.Error("flushing views", "error", err)
 	}
+
+	if db.deletions != nil {
+		if err := db.deletions.Close(); err != nil {
+			logger.Default.Error("closing the deletions listener", "error", err)
+		}
+	}
+
+	return db.db.Close()
 }
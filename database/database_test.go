@@ -0,0 +1,111 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/config"
+)
+
+// Tests needing PostgreSQL run against the server at NEKOBIN_TEST_DATABASE, such
+// as postgres://localhost/nekobin?sslmode=disable, each in a schema of its
+// own. They are skipped when it's not set.
+func testDB(t *testing.T) *sqlx.DB {
+	uri := os.Getenv("NEKOBIN_TEST_DATABASE")
+
+	if uri == "" {
+		t.Skip("NEKOBIN_TEST_DATABASE is not set")
+	}
+
+	admin, err := sqlx.Connect("postgres", uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fmt.Sprintf("nekobin_test_%d", time.Now().UnixNano())
+
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	db, err := sqlx.Connect("postgres", withSearchPath(uri, schema))
+
+	t.Cleanup(func() {
+		if db != nil {
+			db.Close()
+		}
+
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+
+		admin.Close()
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// Connection parameters unknown to the driver are sent to the server as settings
+func withSearchPath(uri, schema string) string {
+	if !strings.HasPrefix(uri, "postgres://") && !strings.HasPrefix(uri, "postgresql://") {
+		return uri + " search_path=" + schema
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+var testTimeouts = config.Timeouts{Read: 5 * time.Second, Write: 5 * time.Second, Views: 5 * time.Second}
@@ -25,63 +25,101 @@
 package database
 
 import (
-	"fmt"
-	"log"
-	"sync"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/nekobin/nekobin/compress"
+	"github.com/nekobin/nekobin/config"
 	"github.com/nekobin/nekobin/keygen"
+	"github.com/nekobin/nekobin/logger"
+	"github.com/nekobin/nekobin/storage"
+	"github.com/nekobin/nekobin/tracing"
 )
 
+var errExists = errors.New("document exists")
+
 type Document struct {
-	Key     string  `json:"key"`
-	Title   *string `json:"title"`
-	Author  *string `json:"author"`
-	Date    int     `json:"date"`
-	Views   int     `json:"views"`
-	Length  int     `json:"length"`
-	Content string  `json:"content"`
+	Key                string   `json:"key"`
+	Title              *string  `json:"title"`
+	Author             *string  `json:"author"`
+	Language           *string  `json:"language"`
+	LanguageConfidence *float64 `json:"language_confidence" db:"language_confidence"`
+	Date               int      `json:"date"`
+	Views              int      `json:"views"`
+	Length             int      `json:"length"`
+	Digest             string   `json:"digest"`
+	Content            string   `json:"content"`
+	// Large contents are kept out of the database and not loaded in Content,
+	// use DocumentsQuery.Open to read them.
+	External bool `json:"external"`
+
+	// Content as stored, when compressed
+	Data     []byte  `json:"-"`
+	Encoding *string `json:"-"`
 }
 
 type DocumentsQuery interface {
-	Select(key string) (doc *Document, err error)
-	Insert(title, author *string, content string) (doc *Document, err error)
-	Exists(key string) (exists bool, err error)
-	IncrementViews(key, ip string)
-}
-
-type ViewIPsKey struct {
-	documentKey string
-	ipAddress   string
+	Select(ctx context.Context, key string) (doc *Document, err error)
+	Insert(ctx context.Context, title, author, language *string, confidence float64, content string) (doc *Document, err error)
+	InsertSpool(ctx context.Context, title, author, language *string, confidence float64, spool *storage.Spool) (doc *Document, err error)
+	Open(ctx context.Context, doc *Document) (content io.ReadCloser, err error)
+	Delete(ctx context.Context, key string) (err error)
+	Exists(ctx context.Context, key string) (exists bool, err error)
+	IncrementViews(ctx context.Context, key, ip string)
+	Keys(ctx context.Context, before time.Time) (keys []string, err error)
+	Restore(ctx context.Context, doc *Document) (created bool, err error)
+	RestoreSpool(ctx context.Context, doc *Document, spool *storage.Spool) (created bool, err error)
+	Sweep(ctx context.Context) (count int, err error)
 }
 
 type Documents struct {
 	*sqlx.DB
 
-	keygen  keygen.Keygen
-	viewIPs map[ViewIPsKey]time.Time
-	mu      *sync.Mutex
+	blobs    *blobs
+	keygen   keygen.Keygen
+	views    *Views
+	timeouts config.Timeouts
 }
 
-func NewDocuments(db *sqlx.DB) *Documents {
+func NewDocuments(db *sqlx.DB, cfg *config.Database, store storage.Store, threshold int64, views *Views) *Documents {
 	return &Documents{
-		DB:      db,
-		keygen:  keygen.NewPhoneticKeygen(),
-		viewIPs: make(map[ViewIPsKey]time.Time),
-		mu:      &sync.Mutex{},
+		DB: db,
+		blobs: &blobs{
+			encoding:  cfg.Compression,
+			minLength: cfg.CompressionMinLength,
+			store:     store,
+			threshold: threshold,
+		},
+		keygen:   keygen.NewPhoneticKeygen(),
+		views:    views,
+		timeouts: cfg.Timeouts,
 	}
 }
 
-func (docs *Documents) Select(key string) (doc *Document, err error) {
-	row := docs.QueryRowx(`
+func (docs *Documents) Select(ctx context.Context, key string) (doc *Document, err error) {
+	ctx, span := startSpan(ctx, "Documents.Select")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, docs.timeouts.Read)
+	defer cancel()
+
+	row := docs.QueryRowxContext(ctx, `
 		SELECT
-			key, title, author,
-			extract(EPOCH FROM date AT TIME ZONE 'utc')::INT date,
-			views, length, content
-		FROM documents
-		WHERE key = $1
+			d.key, d.title, d.author, d.language, d.language_confidence,
+			extract(EPOCH FROM d.date AT TIME ZONE 'utc')::INT date,
+			d.views, b.length, b.digest, COALESCE(b.content, '') content, b.data, b.encoding, b.external
+		FROM documents d
+		JOIN blobs b ON b.digest = d.digest
+		WHERE d.key = $1
 		LIMIT 1`,
 		key,
 	)
@@ -89,10 +127,50 @@ func (docs *Documents) Select(key string) (doc *Document, err error) {
 	doc = &Document{}
 	err = row.StructScan(doc)
 
+	if err == nil && doc.Encoding != nil {
+		var content []byte
+		content, err = compress.Decode(*doc.Encoding, doc.Data)
+		doc.Content = string(content)
+	}
+
 	return
 }
 
-func (docs *Documents) Insert(title, author *string, content string) (doc *Document, err error) {
+func (docs *Documents) Insert(ctx context.Context, title, author, language *string, confidence float64, content string) (doc *Document, err error) {
+	ctx, span := startSpan(ctx, "Documents.Insert")
+	defer func() { tracing.End(span, err) }()
+
+	digest, size := Digest(content), int64(len(content))
+
+	return docs.insert(ctx, title, author, language, confidence, digest, size, func() error {
+		return docs.blobs.put(ctx, digest, strings.NewReader(content), size)
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		return docs.blobs.acquire(ctx, tx, digest, content)
+	})
+}
+
+// InsertSpool inserts a document whose content has been spooled, as it
+// may be too large to be held in memory.
+func (docs *Documents) InsertSpool(ctx context.Context, title, author, language *string, confidence float64, spool *storage.Spool) (doc *Document, err error) {
+	ctx, span := startSpan(ctx, "Documents.InsertSpool")
+	defer func() { tracing.End(span, err) }()
+
+	return docs.insert(ctx, title, author, language, confidence, spool.Digest, spool.Size, func() error {
+		return docs.blobs.put(ctx, spool.Digest, spool, spool.Size)
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		return docs.blobs.acquireSpool(ctx, tx, spool)
+	})
+}
+
+func (docs *Documents) insert(
+	ctx context.Context,
+	title, author, language *string,
+	confidence float64,
+	digest string,
+	size int64,
+	put func() error,
+	acquire func(ctx context.Context, tx *sqlx.Tx) error,
+) (doc *Document, err error) {
 	if title != nil && *title == "" {
 		title = nil
 	}
@@ -101,71 +179,333 @@ func (docs *Documents) Insert(title, author *string, content string) (doc *Docum
 		author = nil
 	}
 
-	var key string
-	for {
-		key = docs.keygen.GenerateKey()
-		exists, err := docs.Exists(key)
+	var languageConfidence *float64
+
+	if language != nil && *language == "" {
+		language = nil
+	}
+
+	if language != nil {
+		languageConfidence = &confidence
+	}
+
+	key, err := docs.newKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = docs.insertWith(ctx, digest, size, put, acquire, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO documents (key, title, author, language, language_confidence, digest)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			key, title, author, language, languageConfidence, digest,
+		)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err = docs.Select(ctx, key)
+
+	if err == nil {
+		documentsCreated.Inc()
+		documentBytes.Add(float64(doc.Length))
+	}
+
+	return
+}
+
+// Run insert in a transaction, along with taking a reference to the content
+// of the document. External contents are reserved and put in the store ahead
+// of it instead, the reservation is released should insert fail.
+func (docs *Documents) insertWith(
+	ctx context.Context,
+	digest string,
+	size int64,
+	put func() error,
+	acquire func(ctx context.Context, tx *sqlx.Tx) error,
+	insert func(ctx context.Context, tx *sqlx.Tx) error,
+) error {
+	if !docs.blobs.isExternal(size) {
+		return docs.transaction(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := acquire(ctx, tx); err != nil {
+				return err
+			}
+
+			return insert(ctx, tx)
+		})
+	}
+
+	var external bool
+
+	err := docs.transaction(ctx, func(ctx context.Context, tx *sqlx.Tx) (err error) {
+		external, err = docs.blobs.reserve(ctx, tx, digest, size)
+		return
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Put even if the blob existed: whoever reserved it may not be done yet
+	if external {
+		err = put()
+	}
+
+	if err == nil {
+		err = docs.transaction(ctx, insert)
+	}
+
+	if err != nil {
+		docs.unreserve(ctx, digest)
+	}
+
+	return err
+}
+
+// Release the reservation of a blob, even if ctx is done already
+func (docs *Documents) unreserve(ctx context.Context, digest string) {
+	err := docs.transaction(detach(ctx), func(ctx context.Context, tx *sqlx.Tx) error {
+		return docs.blobs.release(ctx, tx, digest)
+	})
+
+	if err != nil {
+		logger.FromContext(ctx).Error("releasing blob", "digest", digest, "error", err)
+	}
+}
+
+// Open the content of a document for reading, whether it's external or not.
+// Readers of external contents may implement io.Seeker too. Reading is bound
+// to ctx, without timeout.
+func (docs *Documents) Open(ctx context.Context, doc *Document) (content io.ReadCloser, err error) {
+	if doc.External {
+		ctx, span := startSpan(ctx, "Documents.Open")
+		defer func() { tracing.End(span, err) }()
+
+		return docs.blobs.open(ctx, doc.Digest)
+	}
 
+	return ioutil.NopCloser(strings.NewReader(doc.Content)), nil
+}
+
+// Delete a document, along with its content if no other document shares it.
+// External contents are only deleted from the store by Sweep.
+func (docs *Documents) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := startSpan(ctx, "Documents.Delete")
+	defer func() { tracing.End(span, err) }()
+
+	return docs.transaction(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		var digest string
+
+		err := tx.QueryRowxContext(ctx, "DELETE FROM documents WHERE key = $1 RETURNING digest", key).Scan(&digest)
 		if err != nil {
-			log.Println(err)
-			return nil, err
+			return err
 		}
 
-		if !exists {
-			break
+		// Other instances drop it from their cache once committed
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", deletionsChannel, key); err != nil {
+			return err
 		}
+
+		return docs.blobs.release(ctx, tx, digest)
+	})
+}
+
+// Sweep deletes the external blobs no document references anymore, contents
+// included, and returns how many.
+func (docs *Documents) Sweep(ctx context.Context) (count int, err error) {
+	ctx, span := startSpan(ctx, "Documents.Sweep")
+	defer func() { tracing.End(span, err) }()
+
+	if docs.blobs.store == nil {
+		return 0, nil
 	}
 
-	rows, err := docs.Query(
-		"INSERT INTO documents (key, title, author, length, content) VALUES ($1, $2, $3, $4, $5)",
-		key, title, author, len(content), content,
-	)
+	for {
+		var found bool
 
-	if err == nil {
-		defer func() {
-			err := rows.Close()
+		err = docs.transaction(ctx, func(ctx context.Context, tx *sqlx.Tx) (err error) {
+			found, err = docs.blobs.sweep(ctx, tx)
+			return
+		})
 
-			if err != nil {
-				log.Println(err)
-			}
-		}()
+		if err != nil || !found {
+			return
+		}
+
+		count++
 	}
+}
 
-	doc, err = docs.Select(key)
+func (docs *Documents) Exists(ctx context.Context, key string) (exists bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, docs.timeouts.Read)
+	defer cancel()
+
+	row := docs.QueryRowxContext(ctx, "SELECT EXISTS(SELECT 1 FROM documents WHERE key = $1)", key)
+	err = row.Scan(&exists)
 
 	return
 }
 
-func (docs *Documents) Exists(key string) (exists bool, err error) {
-	row := docs.QueryRowx("SELECT EXISTS(SELECT 1 FROM documents WHERE key = $1)", key)
-	err = row.Scan(&exists)
+// Keys of the documents created before a date, or of every document when it's
+// zero, oldest first.
+func (docs *Documents) Keys(ctx context.Context, before time.Time) (keys []string, err error) {
+	ctx, span := startSpan(ctx, "Documents.Keys")
+	defer func() { tracing.End(span, err) }()
+
+	if before.IsZero() {
+		err = docs.SelectContext(ctx, &keys, "SELECT key FROM documents ORDER BY date, key")
+	} else {
+		err = docs.SelectContext(
+			ctx, &keys,
+			"SELECT key FROM documents WHERE date < $1::TIMESTAMPTZ AT TIME ZONE 'utc' ORDER BY date, key",
+			before.UTC(),
+		)
+	}
 
 	return
 }
 
-func (docs *Documents) IncrementViews(key, ip string) {
-	docs.mu.Lock()
-	defer docs.mu.Unlock()
+// Restore a document as it was, key, date and views included, such as from
+// a backup. Existing documents are left untouched, created is false then.
+// Documents without a key get a new one.
+func (docs *Documents) Restore(ctx context.Context, doc *Document) (created bool, err error) {
+	ctx, span := startSpan(ctx, "Documents.Restore")
+	defer func() { tracing.End(span, err) }()
 
-	viewIPsKey := ViewIPsKey{key, ip}
-	value, exists := docs.viewIPs[viewIPsKey]
+	digest, size := Digest(doc.Content), int64(len(doc.Content))
 
-	if exists && time.Now().Sub(value).Minutes() < 30 {
-		return
+	return docs.restore(ctx, doc, digest, size, func() error {
+		return docs.blobs.put(ctx, digest, strings.NewReader(doc.Content), size)
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		return docs.blobs.acquire(ctx, tx, digest, doc.Content)
+	})
+}
+
+// RestoreSpool restores a document whose content has been spooled. The same
+// spool may be used for several documents.
+func (docs *Documents) RestoreSpool(ctx context.Context, doc *Document, spool *storage.Spool) (created bool, err error) {
+	ctx, span := startSpan(ctx, "Documents.RestoreSpool")
+	defer func() { tracing.End(span, err) }()
+
+	return docs.restore(ctx, doc, spool.Digest, spool.Size, func() error {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return docs.blobs.put(ctx, spool.Digest, spool, spool.Size)
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return docs.blobs.acquireSpool(ctx, tx, spool)
+	})
+}
+
+func (docs *Documents) restore(
+	ctx context.Context,
+	doc *Document,
+	digest string,
+	size int64,
+	put func() error,
+	acquire func(ctx context.Context, tx *sqlx.Tx) error,
+) (created bool, err error) {
+	if doc.Key == "" {
+		if doc.Key, err = docs.newKey(ctx); err != nil {
+			return false, err
+		}
 	}
 
-	docs.viewIPs[viewIPsKey] = time.Now()
+	// Spare putting a content in the blob store for nothing
+	if exists, err := docs.Exists(ctx, doc.Key); err != nil || exists {
+		return false, err
+	}
 
-	rows, err := docs.Query("UPDATE documents SET views = views + 1 WHERE key = $1", key)
+	err = docs.insertWith(ctx, digest, size, put, acquire, func(ctx context.Context, tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO documents (key, title, author, language, language_confidence, date, views, digest)
+			VALUES ($1, $2, $3, $4, $5, to_timestamp($6) AT TIME ZONE 'utc', $7, $8)
+			ON CONFLICT (key) DO NOTHING`,
+			doc.Key, doc.Title, doc.Author, doc.Language, doc.LanguageConfidence, doc.Date, doc.Views, digest,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		// Created in the meantime, the blob reference must be rolled back
+		if n == 0 {
+			return errExists
+		}
+
+		return nil
+	})
+
+	if err == errExists {
+		return false, nil
+	}
 
 	if err != nil {
-		fmt.Println(err)
-		return
+		return false, err
+	}
+
+	documentsCreated.Inc()
+	documentBytes.Add(float64(size))
+
+	return true, nil
+}
+
+// Generate a key no document has yet
+func (docs *Documents) newKey(ctx context.Context) (string, error) {
+	for {
+		key := docs.keygen.GenerateKey()
+
+		exists, err := docs.Exists(ctx, key)
+		if err != nil || !exists {
+			return key, err
+		}
 	}
+}
 
-	err = rows.Close()
+// Run fn in a transaction bound by the write timeout, committed only if it doesn't fail
+func (docs *Documents) transaction(ctx context.Context, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, docs.timeouts.Write)
+	defer cancel()
 
+	tx, err := docs.BeginTxx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+
+	if err := fn(ctx, tx); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			logger.FromContext(ctx).Error("rolling back transaction", "error", err)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Count a view of a document. Views are written in batches later on, with
+// a context of their own.
+func (docs *Documents) IncrementViews(_ context.Context, key, ip string) {
+	docs.views.Add(key, ip)
+}
+
+// Start a span for a database operation
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name, semconv.DBSystemPostgreSQL)
 }
@@ -0,0 +1,81 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/storage"
+)
+
+// Importing the same archive twice restores its documents once
+func TestRestoreSpoolTwice(t *testing.T) {
+	db := testDB(t)
+	docs := NewDocuments(db, &config.Database{Timeouts: testTimeouts}, nil, 0, nil)
+	ctx := context.Background()
+
+	spool, err := storage.NewSpool(t.TempDir(), strings.NewReader("restored"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer spool.Close()
+
+	restored := []*Document{
+		{Key: "first", Date: 1600000000, Views: 3},
+		{Key: "second", Date: 1600000000},
+	}
+
+	for _, expected := range []bool{true, false} {
+		for _, doc := range restored {
+			restore := *doc
+
+			created, err := docs.RestoreSpool(ctx, &restore, spool)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if created != expected {
+				t.Errorf("%s: created %v, expected %v", doc.Key, created, expected)
+			}
+		}
+	}
+
+	if blob := selectBlob(t, db, spool.Digest); blob == nil || blob.Refcount != 2 {
+		t.Errorf("got blob %+v, expected 2 references", blob)
+	}
+
+	doc, err := docs.Select(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Content != "restored" || doc.Date != 1600000000 || doc.Views != 3 {
+		t.Errorf("got %+v", doc)
+	}
+}
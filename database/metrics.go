@@ -0,0 +1,73 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	documentsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nekobin_documents_created_total",
+		Help: "Documents created.",
+	})
+
+	documentBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nekobin_document_bytes_total",
+		Help: "Bytes of content of the documents created, before deduplication and compression.",
+	})
+)
+
+// Expose the state of the connection pool, views and cache
+func registerMetrics(db *sqlx.DB, views *Views, cache *DocumentsCache) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.DB, "nekobin"))
+
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{Name: "nekobin_views_pending", Help: "Views waiting to be written."},
+		func() float64 { return float64(views.Pending()) },
+	)
+
+	if cache == nil {
+		return
+	}
+
+	promauto.NewCounterFunc(
+		prometheus.CounterOpts{Name: "nekobin_cache_hits_total", Help: "Documents read from the cache."},
+		func() float64 { return float64(cache.Stats().Hits) },
+	)
+
+	promauto.NewCounterFunc(
+		prometheus.CounterOpts{Name: "nekobin_cache_misses_total", Help: "Documents missing from the cache."},
+		func() float64 { return float64(cache.Stats().Misses) },
+	)
+
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{Name: "nekobin_cache_size_bytes", Help: "Size of the cached documents."},
+		func() float64 { return float64(cache.Stats().Size) },
+	)
+}
@@ -0,0 +1,332 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/logger"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Key of the advisory lock held while migrating, arbitrary but fixed
+const migrationsLock = 0x6e656b6f
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Parts of migrations that can't be done in SQL, by version. Each runs right
+// after the up file, in the same transaction.
+var migrationSteps = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	5: digestCompressed,
+}
+
+// Migration is a numbered change to the schema, along with how to revert it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	step func(ctx context.Context, tx *sql.Tx) error
+}
+
+type MigrationStatus struct {
+	Migration
+	// When it was applied, nil if it's pending
+	Applied *time.Time
+}
+
+// Migrator applies the migrations embedded in the binary. Versions applied
+// are recorded in the schema_migrations table, and an advisory lock is held
+// meanwhile so that several instances starting at once don't race.
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies the pending migrations in order, each in a transaction of its own.
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn, done map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			err := runMigration(ctx, conn, migration.Up, migration.step,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+				migration.Version, migration.Name,
+			)
+
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			logger.FromContext(ctx).Info("applied migration", "version", migration.Version, "name", migration.Name)
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// Down reverts the n latest applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) (reverted []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn, done map[int]time.Time) error {
+		versions := make([]int, 0, len(done))
+
+		for version := range done {
+			versions = append(versions, version)
+		}
+
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for i := 0; i < n && i < len(versions); i++ {
+			migration, ok := m.find(versions[i])
+			if !ok {
+				return fmt.Errorf("migration %04d is unknown to this version of nekobin", versions[i])
+			}
+
+			err := runMigration(ctx, conn, migration.Down, nil,
+				"DELETE FROM schema_migrations WHERE version = $1",
+				migration.Version,
+			)
+
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			logger.FromContext(ctx).Info("reverted migration", "version", migration.Version, "name", migration.Name)
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// Status lists every migration, applied or not.
+func (m *Migrator) Status(ctx context.Context) (status []MigrationStatus, err error) {
+	err = m.locked(ctx, func(_ *sql.Conn, done map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			s := MigrationStatus{Migration: migration}
+
+			if applied, ok := done[migration.Version]; ok {
+				s.Applied = &applied
+			}
+
+			status = append(status, s)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+// Run fn holding the migrations lock, on a connection of its own since
+// advisory locks belong to sessions, along with the versions applied so far.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, done map[int]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLock); err != nil {
+		return err
+	}
+
+	defer func() {
+		// Released along with the session anyway, should this fail
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLock); err != nil {
+			logger.FromContext(ctx).Error("releasing the migrations lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version INTEGER PRIMARY KEY,
+			name    TEXT        NOT NULL,
+			applied TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+
+	for rows.Next() {
+		var version int
+		var applied time.Time
+
+		if err := rows.Scan(&version, &applied); err != nil {
+			return err
+		}
+
+		done[version] = applied
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
+
+// Run a migration, along with its step if any, and record it, all or nothing
+func runMigration(
+	ctx context.Context,
+	conn *sql.Conn,
+	migration string,
+	step func(ctx context.Context, tx *sql.Tx) error,
+	record string,
+	args ...interface{},
+) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if step != nil {
+		if err := step(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Read the embedded migrations, sorted by version. Each must come with both
+// its up and down files.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2], step: migrationSteps[version]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s lacks its up or down file", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
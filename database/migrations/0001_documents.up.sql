@@ -22,7 +22,9 @@
  * SOFTWARE.
  */
 
-CREATE TABLE documents
+-- The schema as it was before migrations, databases set up by hand with it
+-- already have this table
+CREATE TABLE IF NOT EXISTS documents
 (
     key     TEXT PRIMARY KEY,
     title   TEXT               DEFAULT NULL,
@@ -31,4 +33,4 @@ CREATE TABLE documents
     views   INTEGER   NOT NULL DEFAULT 0,
     length  INTEGER   NOT NULL,
     content TEXT      NOT NULL
-)
+);
@@ -0,0 +1,41 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+-- Contents move to blobs, stored once and shared by the documents having them
+CREATE TABLE blobs
+(
+    digest   TEXT PRIMARY KEY,
+    refcount INTEGER NOT NULL DEFAULT 0,
+    length   INTEGER NOT NULL,
+    content  TEXT    DEFAULT NULL,
+    data     BYTEA   DEFAULT NULL,
+    encoding TEXT    DEFAULT NULL
+);
+
+ALTER TABLE documents ADD COLUMN digest TEXT DEFAULT NULL;
+
+-- Compressed contents can't be hashed here, they're done in Go afterwards
+UPDATE documents
+SET digest = encode(sha256(convert_to(content, 'UTF8')), 'hex')
+WHERE content IS NOT NULL;
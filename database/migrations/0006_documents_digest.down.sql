@@ -0,0 +1,41 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+DROP INDEX documents_digest_idx;
+
+ALTER TABLE documents
+    DROP CONSTRAINT documents_digest_fkey,
+    ADD COLUMN length   INTEGER DEFAULT NULL,
+    ADD COLUMN content  TEXT    DEFAULT NULL,
+    ADD COLUMN data     BYTEA   DEFAULT NULL,
+    ADD COLUMN encoding TEXT    DEFAULT NULL,
+    ALTER COLUMN digest DROP NOT NULL;
+
+UPDATE documents d
+SET length = b.length, content = b.content, data = b.data, encoding = b.encoding
+FROM blobs b
+WHERE b.digest = d.digest;
+
+-- Every document references a blob, which knows its length
+ALTER TABLE documents ALTER COLUMN length SET NOT NULL;
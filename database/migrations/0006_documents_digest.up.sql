@@ -0,0 +1,40 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+-- Every document has its digest by now
+INSERT INTO blobs (digest, refcount, length, content, data, encoding)
+SELECT DISTINCT ON (digest) digest, count(*) OVER (PARTITION BY digest), length, content, data, encoding
+FROM documents
+ORDER BY digest;
+
+-- Contents are only kept in blobs from now on
+ALTER TABLE documents
+    ALTER COLUMN digest SET NOT NULL,
+    ADD FOREIGN KEY (digest) REFERENCES blobs (digest),
+    DROP COLUMN length,
+    DROP COLUMN content,
+    DROP COLUMN data,
+    DROP COLUMN encoding;
+
+CREATE INDEX documents_digest_idx ON documents (digest);
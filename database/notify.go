@@ -0,0 +1,81 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nekobin/nekobin/logger"
+)
+
+// Channel notified with the key of every deleted document
+const deletionsChannel = "documents_deleted"
+
+// Keep the cache in sync with deletions made elsewhere, such as by other
+// instances or the delete command, until Close is called.
+type deletions struct {
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+func listenDeletions(uri string, cache *DocumentsCache) (*deletions, error) {
+	listener := pq.NewListener(uri, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Default.Error("listening to deletions", "error", err)
+		}
+	})
+
+	if err := listener.Listen(deletionsChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	d := &deletions{listener: listener, done: make(chan struct{})}
+
+	go func() {
+		defer close(d.done)
+
+		for n := range listener.Notify {
+			// Reconnected: deletions may have been missed meanwhile
+			if n == nil {
+				cache.Clear()
+				continue
+			}
+
+			cache.Invalidate(n.Extra)
+		}
+	}()
+
+	return d, nil
+}
+
+func (d *deletions) Close() error {
+	err := d.listener.Close()
+	<-d.done
+
+	return err
+}
@@ -0,0 +1,58 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import "context"
+
+// Stats sums up what the database holds.
+type Stats struct {
+	Documents int64 `db:"documents"`
+	Views     int64 `db:"views"`
+	// Distinct contents, shared by documents with the same one
+	Blobs int64 `db:"blobs"`
+	Bytes int64 `db:"bytes"`
+	// Of which kept in the blob store
+	ExternalBlobs int64 `db:"external_blobs"`
+	ExternalBytes int64 `db:"external_bytes"`
+	Uploads       int64 `db:"uploads"`
+}
+
+func (db *Database) Stats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{}
+
+	err := db.db.GetContext(ctx, stats, `
+		SELECT
+			(SELECT count(*) FROM documents) documents,
+			(SELECT COALESCE(sum(views), 0) FROM documents) views,
+			count(*) blobs,
+			COALESCE(sum(length), 0) bytes,
+			count(*) FILTER (WHERE external) external_blobs,
+			COALESCE(sum(length) FILTER (WHERE external), 0) external_bytes,
+			(SELECT count(*) FROM uploads WHERE expires > now()) uploads
+		FROM blobs`,
+	)
+
+	return stats, err
+}
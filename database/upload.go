@@ -0,0 +1,435 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/config"
+)
+
+// Uploads are appended to in chunks of at most this size, one transaction each
+const uploadChunkSize = 1 << 20
+
+// How long a lock on an upload lasts, unless the request holding it keeps
+// writing to it
+const uploadLockTimeout = time.Minute
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrUploadExpired  = errors.New("upload expired")
+	// Returned when an upload is locked by someone else, or no longer by the
+	// holder of a lock which expired
+	ErrUploadLocked = errors.New("upload locked")
+	// Returned when an upload has been written to by someone else meanwhile
+	ErrUploadConflict = errors.New("upload offset moved")
+)
+
+// Upload is a document being uploaded in chunks, which becomes a Document
+// once complete.
+type Upload struct {
+	ID       string
+	Length   int64
+	Offset   int64 `db:"received"`
+	Title    *string
+	Author   *string
+	Language *string
+	// Key of the resulting document, once complete
+	Document *string
+	Expires  time.Time
+}
+
+type UploadsQuery interface {
+	Create(ctx context.Context, length int64, title, author, language *string, expires time.Time) (upload *Upload, err error)
+	Select(ctx context.Context, id string) (upload *Upload, err error)
+	Append(ctx context.Context, upload *Upload, lock string, content io.Reader, expires time.Time) (err error)
+	Open(ctx context.Context, upload *Upload) (content io.Reader)
+	Finish(ctx context.Context, upload *Upload, key string) (err error)
+	Delete(ctx context.Context, id string) (err error)
+	DeleteExpired(ctx context.Context) (count int, err error)
+	Lock(ctx context.Context, id string) (lock string, err error)
+	Unlock(ctx context.Context, id, lock string) (err error)
+}
+
+// Uploads keeps uploads in the database, partial contents included, so that
+// they can be resumed on any instance.
+type Uploads struct {
+	*sqlx.DB
+
+	timeouts config.Timeouts
+}
+
+func NewUploads(db *sqlx.DB, timeouts config.Timeouts) *Uploads {
+	return &Uploads{
+		DB:       db,
+		timeouts: timeouts,
+	}
+}
+
+func (uploads *Uploads) Create(ctx context.Context, length int64, title, author, language *string, expires time.Time) (upload *Upload, err error) {
+	// Anyone knowing the ID can write to the upload, so it must not be guessable
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	upload = &Upload{
+		ID:       id,
+		Length:   length,
+		Title:    title,
+		Author:   author,
+		Language: language,
+		Expires:  expires,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Write)
+	defer cancel()
+
+	_, err = uploads.ExecContext(
+		ctx,
+		`INSERT INTO uploads (id, length, title, author, language, expires)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		upload.ID, length, title, author, language, expires,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// Select an upload. Fails with ErrUploadNotFound or ErrUploadExpired, as
+// expired uploads are kept until they are cleaned up.
+func (uploads *Uploads) Select(ctx context.Context, id string) (upload *Upload, err error) {
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Read)
+	defer cancel()
+
+	var row struct {
+		Upload
+		Expired bool
+	}
+
+	err = uploads.QueryRowxContext(ctx, `
+		SELECT id, length, received, title, author, language, document, expires, expires <= now() expired
+		FROM uploads
+		WHERE id = $1
+		LIMIT 1`,
+		id,
+	).StructScan(&row)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrUploadNotFound
+	case err != nil:
+		return nil, err
+	case row.Expired:
+		return nil, ErrUploadExpired
+	}
+
+	return &row.Upload, nil
+}
+
+// Append content at the current offset of an upload, up to its length, and
+// push its expiration back. Content is written in chunks, each along with the
+// new offset, so that whatever could be written counts even when reading
+// content fails midway or ctx is cancelled. The upload must be locked with
+// lock, which is renewed with every chunk. Fails with ErrUploadLocked if the
+// lock expired and was taken over, or ErrUploadConflict if someone else wrote
+// to the upload meanwhile.
+func (uploads *Uploads) Append(ctx context.Context, upload *Upload, lock string, content io.Reader, expires time.Time) (err error) {
+	content = io.LimitReader(content, upload.Length-upload.Offset)
+	chunk := make([]byte, uploadChunkSize)
+
+	for {
+		n, readErr := io.ReadFull(content, chunk)
+
+		if n > 0 {
+			if err := uploads.appendChunk(ctx, upload, lock, chunk[:n], expires); err != nil {
+				return err
+			}
+		}
+
+		switch readErr {
+		case nil:
+			continue
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		}
+
+		return readErr
+	}
+}
+
+// Write a chunk at the offset of an upload, provided it's still the one
+// recorded and the upload is still locked with lock
+func (uploads *Uploads) appendChunk(ctx context.Context, upload *Upload, lock string, chunk []byte, expires time.Time) error {
+	// Written even if ctx is cancelled, the chunk has been received
+	ctx, cancel := context.WithTimeout(detach(ctx), uploads.timeouts.Write)
+	defer cancel()
+
+	tx, err := uploads.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var received int64
+	var locked bool
+
+	err = tx.QueryRowxContext(
+		ctx,
+		"SELECT received, lock_token IS NOT DISTINCT FROM $2 FROM uploads WHERE id = $1 FOR UPDATE",
+		upload.ID, lock,
+	).Scan(&received, &locked)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrUploadNotFound
+	case err != nil:
+		return err
+	case !locked:
+		return ErrUploadLocked
+	case received != upload.Offset:
+		return ErrUploadConflict
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE uploads
+		SET received = received + $2, expires = $3, locked_until = now() + $4 * INTERVAL '1 second'
+		WHERE id = $1`,
+		upload.ID, len(chunk), expires, uploadLockTimeout.Seconds(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO upload_chunks (id, position, data) VALUES ($1, $2, $3)",
+		upload.ID, upload.Offset, chunk,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	upload.Offset += int64(len(chunk))
+	upload.Expires = expires
+
+	return nil
+}
+
+// Open the content of a complete upload for reading, a chunk at a time.
+func (uploads *Uploads) Open(ctx context.Context, upload *Upload) (content io.Reader) {
+	return &uploadReader{uploads: uploads, ctx: ctx, upload: upload}
+}
+
+// Finish an upload, recording the key of the document made out of it. The
+// upload is kept until it expires, so that clients can look the key up, but
+// not its content.
+func (uploads *Uploads) Finish(ctx context.Context, upload *Upload, key string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Write)
+	defer cancel()
+
+	tx, err := uploads.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE uploads SET document = $2 WHERE id = $1", upload.ID, key); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM upload_chunks WHERE id = $1", upload.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	upload.Document = &key
+
+	return nil
+}
+
+// Delete an upload, along with its content.
+func (uploads *Uploads) Delete(ctx context.Context, id string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Write)
+	defer cancel()
+
+	_, err = uploads.ExecContext(ctx, "DELETE FROM uploads WHERE id = $1", id)
+
+	return
+}
+
+// Delete expired uploads and their contents, returning how many there were.
+func (uploads *Uploads) DeleteExpired(ctx context.Context) (count int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Write)
+	defer cancel()
+
+	result, err := uploads.ExecContext(ctx, "DELETE FROM uploads WHERE expires <= now()")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
+
+// Lock an upload, so that a single request at a time writes to it, whichever
+// instance serves it. The returned lock is needed to write to the upload and
+// unlock it. Fails with ErrUploadLocked if it's already locked, or with
+// ErrUploadNotFound or ErrUploadExpired. Locks expire on their own should
+// their holder vanish, unless it keeps appending.
+func (uploads *Uploads) Lock(ctx context.Context, id string) (lock string, err error) {
+	lock, err = randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploads.timeouts.Write)
+	defer cancel()
+
+	result, err := uploads.ExecContext(
+		ctx,
+		`UPDATE uploads SET lock_token = $2, locked_until = now() + $3 * INTERVAL '1 second'
+		WHERE id = $1 AND expires > now() AND (locked_until IS NULL OR locked_until <= now())`,
+		id, lock, uploadLockTimeout.Seconds(),
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return lock, err
+	}
+
+	// Tell why it could not be locked
+	var expired bool
+
+	err = uploads.QueryRowxContext(ctx, "SELECT expires <= now() FROM uploads WHERE id = $1", id).Scan(&expired)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return "", ErrUploadNotFound
+	case err != nil:
+		return "", err
+	case expired:
+		return "", ErrUploadExpired
+	}
+
+	return "", ErrUploadLocked
+}
+
+// Unlock an upload, unless its lock expired and someone else holds it now.
+func (uploads *Uploads) Unlock(ctx context.Context, id, lock string) (err error) {
+	ctx, cancel := context.WithTimeout(detach(ctx), uploads.timeouts.Write)
+	defer cancel()
+
+	_, err = uploads.ExecContext(
+		ctx,
+		"UPDATE uploads SET lock_token = NULL, locked_until = NULL WHERE id = $1 AND lock_token = $2",
+		id, lock,
+	)
+
+	return
+}
+
+// Random hexadecimal token, not guessable
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Reads the chunks of an upload in order, one query each
+type uploadReader struct {
+	uploads *Uploads
+	ctx     context.Context
+	upload  *Upload
+
+	position int64
+	chunk    []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	if len(r.chunk) == 0 {
+		if r.position >= r.upload.Offset {
+			return 0, io.EOF
+		}
+
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+func (r *uploadReader) next() error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.uploads.timeouts.Read)
+	defer cancel()
+
+	err := r.uploads.QueryRowxContext(
+		ctx,
+		"SELECT data FROM upload_chunks WHERE id = $1 AND position = $2",
+		r.upload.ID, r.position,
+	).Scan(&r.chunk)
+
+	if err == sql.ErrNoRows {
+		return io.ErrUnexpectedEOF
+	}
+
+	r.position += int64(len(r.chunk))
+
+	return err
+}
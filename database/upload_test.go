@@ -0,0 +1,129 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadLocks(t *testing.T) {
+	uploads := NewUploads(testDB(t), testTimeouts)
+	ctx := context.Background()
+
+	upload, err := uploads.Create(ctx, 11, nil, nil, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := uploads.Lock(ctx, upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uploads.Lock(ctx, upload.ID); err != ErrUploadLocked {
+		t.Errorf("locking twice: got %v, expected %v", err, ErrUploadLocked)
+	}
+
+	// Someone else's lock is left alone
+	if err := uploads.Unlock(ctx, upload.ID, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uploads.Lock(ctx, upload.ID); err != ErrUploadLocked {
+		t.Errorf("unlocked by someone else: got %v, expected %v", err, ErrUploadLocked)
+	}
+
+	if err := uploads.Append(ctx, upload, "other", strings.NewReader("hello"), upload.Expires); err != ErrUploadLocked {
+		t.Errorf("appending without the lock: got %v, expected %v", err, ErrUploadLocked)
+	}
+
+	if err := uploads.Append(ctx, upload, lock, strings.NewReader("hello "), upload.Expires); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := *upload
+	stale.Offset = 0
+
+	if err := uploads.Append(ctx, &stale, lock, strings.NewReader("hello"), upload.Expires); err != ErrUploadConflict {
+		t.Errorf("appending at a stale offset: got %v, expected %v", err, ErrUploadConflict)
+	}
+
+	if err := uploads.Unlock(ctx, upload.ID, lock); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err = uploads.Lock(ctx, upload.ID)
+	if err != nil {
+		t.Fatalf("locking again: %v", err)
+	}
+
+	if err := uploads.Append(ctx, upload, lock, strings.NewReader("world!"), upload.Expires); err != nil {
+		t.Fatal(err)
+	}
+
+	selected, err := uploads.Select(ctx, upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if selected.Offset != 11 {
+		t.Errorf("offset: got %d, expected 11", selected.Offset)
+	}
+
+	content, err := ioutil.ReadAll(uploads.Open(ctx, selected))
+	if err != nil || string(content) != "hello world" {
+		t.Errorf("content: got %q (%v), expected %q", content, err, "hello world")
+	}
+}
+
+func TestUploadNotFoundOrExpired(t *testing.T) {
+	uploads := NewUploads(testDB(t), testTimeouts)
+	ctx := context.Background()
+
+	if _, err := uploads.Lock(ctx, "unknown"); err != ErrUploadNotFound {
+		t.Errorf("locking unknown: got %v, expected %v", err, ErrUploadNotFound)
+	}
+
+	if _, err := uploads.Select(ctx, "unknown"); err != ErrUploadNotFound {
+		t.Errorf("selecting unknown: got %v, expected %v", err, ErrUploadNotFound)
+	}
+
+	upload, err := uploads.Create(ctx, 10, nil, nil, nil, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uploads.Lock(ctx, upload.ID); err != ErrUploadExpired {
+		t.Errorf("locking expired: got %v, expected %v", err, ErrUploadExpired)
+	}
+
+	if _, err := uploads.Select(ctx, upload.ID); err != ErrUploadExpired {
+		t.Errorf("selecting expired: got %v, expected %v", err, ErrUploadExpired)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/nekobin/nekobin/logger"
+)
+
+// Views from the same IP address are counted once in this window
+const viewWindow = 30 * time.Minute
+
+type view struct {
+	key    string
+	ipHash string
+}
+
+// Views counts document views in batches. Views are deduplicated per hashed IP
+// address in the database, so that it holds across restarts and instances;
+// recently seen ones are skipped in memory beforehand.
+type Views struct {
+	*sqlx.DB
+
+	// IP addresses are never stored as they are
+	secret  []byte
+	pending map[view]bool
+	recent  map[view]time.Time
+	mu      *sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+	closed  sync.Once
+
+	// Each flush may take this long
+	timeout time.Duration
+
+	// Called with the views counted for each document after flushing
+	OnCounted func(key string, count int)
+}
+
+func NewViews(db *sqlx.DB, secret string, timeout time.Duration) *Views {
+	return &Views{
+		DB:      db,
+		secret:  []byte(secret),
+		pending: make(map[view]bool),
+		recent:  make(map[view]time.Time),
+		mu:      &sync.Mutex{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		timeout: timeout,
+	}
+}
+
+// Add a view, counted at the next flush unless it's a repeated one.
+func (views *Views) Add(key, ip string) {
+	v := view{key, views.hash(ip)}
+
+	views.mu.Lock()
+	defer views.mu.Unlock()
+
+	if seen, ok := views.recent[v]; ok && time.Since(seen) < viewWindow {
+		return
+	}
+
+	views.recent[v] = time.Now()
+	views.pending[v] = true
+}
+
+// Pending is the amount of views waiting to be flushed.
+func (views *Views) Pending() int {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+
+	return len(views.pending)
+}
+
+// Flush the pending views to the database in a single batch.
+func (views *Views) Flush(ctx context.Context) error {
+	views.mu.Lock()
+	pending := views.pending
+	views.pending = make(map[view]bool)
+
+	// Forget views past the window, the database decides for those anyway
+	for v, seen := range views.recent {
+		if time.Since(seen) >= viewWindow {
+			delete(views.recent, v)
+		}
+	}
+
+	views.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(pending))
+	ipHashes := make([]string, 0, len(pending))
+
+	for v := range pending {
+		keys = append(keys, v.key)
+		ipHashes = append(ipHashes, v.ipHash)
+	}
+
+	// Only views new or past the window of the previous one count
+	rows, err := views.QueryxContext(ctx, `
+		WITH counted AS (
+			INSERT INTO views (key, ip_hash)
+			SELECT v.key, v.ip_hash
+			FROM unnest($1::TEXT[], $2::TEXT[]) v (key, ip_hash)
+			WHERE EXISTS(SELECT 1 FROM documents d WHERE d.key = v.key)
+			ON CONFLICT (key, ip_hash) DO UPDATE SET date = EXCLUDED.date
+			WHERE views.date <= EXCLUDED.date - $3 * INTERVAL '1 second'
+			RETURNING key
+		)
+		UPDATE documents d
+		SET views = d.views + c.count
+		FROM (SELECT key, count(*) count FROM counted GROUP BY key) c
+		WHERE d.key = c.key
+		RETURNING d.key, c.count`,
+		pq.Array(keys), pq.Array(ipHashes), viewWindow.Seconds(),
+	)
+
+	if err != nil {
+		views.requeue(pending)
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var count int
+
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+
+		if views.OnCounted != nil {
+			views.OnCounted(key, count)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = views.ExecContext(
+		ctx,
+		"DELETE FROM views WHERE date <= now() - $1 * INTERVAL '1 second'",
+		viewWindow.Seconds(),
+	)
+
+	return err
+}
+
+// Start flushing views every interval, until Close is called.
+func (views *Views) Start(interval time.Duration) {
+	views.started = true
+	go views.run(interval)
+}
+
+func (views *Views) run(interval time.Duration) {
+	defer close(views.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := views.flush(); err != nil {
+				logger.Default.Error("flushing views", "error", err)
+			}
+		case <-views.stop:
+			return
+		}
+	}
+}
+
+// Close stops flushing on interval, then flushes the views left. It may be
+// called more than once.
+func (views *Views) Close() error {
+	views.closed.Do(func() {
+		close(views.stop)
+
+		if views.started {
+			<-views.done
+		}
+	})
+
+	return views.flush()
+}
+
+// Flush in the background, with a timeout of its own
+func (views *Views) flush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), views.timeout)
+	defer cancel()
+
+	return views.Flush(ctx)
+}
+
+// Put back views which couldn't be flushed
+func (views *Views) requeue(pending map[view]bool) {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+
+	for v := range pending {
+		views.pending[v] = true
+	}
+}
+
+func (views *Views) hash(ip string) string {
+	mac := hmac.New(sha256.New, views.secret)
+	mac.Write([]byte(ip))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
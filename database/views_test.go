@@ -0,0 +1,191 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/nekobin/nekobin/config"
+)
+
+// Views against a server which doesn't answer, so that every flush fails
+func unreachableViews(t *testing.T) *Views {
+	db, err := sqlx.Open("postgres", "postgres://127.0.0.1:1/nekobin?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return NewViews(db, "secret", time.Second)
+}
+
+func TestViewsDedup(t *testing.T) {
+	views := unreachableViews(t)
+
+	views.Add("a", "1.1.1.1")
+	views.Add("a", "1.1.1.1")
+
+	if got := views.Pending(); got != 1 {
+		t.Errorf("repeated view: %d pending, want 1", got)
+	}
+
+	views.Add("a", "2.2.2.2")
+	views.Add("b", "1.1.1.1")
+
+	if got := views.Pending(); got != 3 {
+		t.Errorf("other IP and document: %d pending, want 3", got)
+	}
+
+	// Once flushed, the same view within the window is still skipped
+	views.pending = make(map[view]bool)
+	views.Add("a", "1.1.1.1")
+
+	if got := views.Pending(); got != 0 {
+		t.Errorf("repeated view after a flush: %d pending, want 0", got)
+	}
+
+	// Past the window, it counts again
+	views.recent[view{"a", views.hash("1.1.1.1")}] = time.Now().Add(-viewWindow)
+	views.Add("a", "1.1.1.1")
+
+	if got := views.Pending(); got != 1 {
+		t.Errorf("view past the window: %d pending, want 1", got)
+	}
+}
+
+func TestViewsRequeue(t *testing.T) {
+	views := unreachableViews(t)
+
+	views.Add("a", "1.1.1.1")
+	views.Add("b", "1.1.1.1")
+
+	if err := views.Flush(context.Background()); err == nil {
+		t.Fatal("flushing to an unreachable database succeeded")
+	}
+
+	if got := views.Pending(); got != 2 {
+		t.Errorf("after a failed flush: %d pending, want 2", got)
+	}
+
+	// Views added meanwhile are kept along
+	views.Add("c", "1.1.1.1")
+
+	if err := views.Close(); err == nil {
+		t.Fatal("flushing to an unreachable database succeeded")
+	}
+
+	if got := views.Pending(); got != 3 {
+		t.Errorf("after a failed flush: %d pending, want 3", got)
+	}
+}
+
+func TestViewsCloseTwice(t *testing.T) {
+	views := unreachableViews(t)
+	views.Start(time.Hour)
+
+	if err := views.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := views.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestViewsFlush(t *testing.T) {
+	db := testDB(t)
+	docs := NewDocuments(db, &config.Database{Timeouts: testTimeouts}, nil, 0, nil)
+	ctx := context.Background()
+
+	var keys []string
+
+	for _, content := range []string{"hello", "world"} {
+		doc, err := docs.Insert(ctx, nil, nil, nil, 0, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, doc.Key)
+	}
+
+	a, b := keys[0], keys[1]
+
+	counted := make(map[string]int)
+	mu := &sync.Mutex{}
+	onCounted := func(key string, count int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		counted[key] += count
+	}
+
+	views := NewViews(db, "secret", testTimeouts.Views)
+	views.OnCounted = onCounted
+
+	// Flushed in a single batch, unknown documents left out
+	views.Add(a, "1.1.1.1")
+	views.Add(a, "2.2.2.2")
+	views.Add(b, "1.1.1.1")
+	views.Add("unknown", "1.1.1.1")
+
+	if err := views.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if counted[a] != 2 || counted[b] != 1 || len(counted) != 2 {
+		t.Errorf("counted %v, want %s: 2 and %s: 1", counted, a, b)
+	}
+
+	// Another instance sees the same view within the window in the database
+	other := NewViews(db, "secret", testTimeouts.Views)
+	other.OnCounted = onCounted
+	other.Add(a, "1.1.1.1")
+	other.Add(b, "3.3.3.3")
+
+	if err := other.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if counted[a] != 2 || counted[b] != 2 {
+		t.Errorf("counted %v, want %s: 2 and %s: 2", counted, a, b)
+	}
+
+	for key, want := range map[string]int{a: 2, b: 2} {
+		doc, err := docs.Select(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.Views != want {
+			t.Errorf("%s has %d views, want %d", key, doc.Views, want)
+		}
+	}
+}
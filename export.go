@@ -0,0 +1,481 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nekobin/nekobin/archive"
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/handlers"
+	"github.com/nekobin/nekobin/importer"
+	"github.com/nekobin/nekobin/storage"
+)
+
+// Export every document into an archive, gzipped when its name ends in .gz.
+// Documents are listed first, so that the contents can follow once each.
+func exportDocuments(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	var gz *gzip.Writer
+
+	if len(args) == 1 {
+		var err error
+
+		file, err = os.Create(args[0])
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		out = file
+
+		if strings.HasSuffix(args[0], ".gz") {
+			gz = gzip.NewWriter(file)
+			out = gz
+		}
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// The list of documents must be complete before being archived
+	lines, err := ioutil.TempFile(cfg.Storage.TempPath, "nekobin-export-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(lines.Name())
+	defer lines.Close()
+
+	keys, err := db.Documents.Keys(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	// Documents of each content, in the order they first appear
+	var digests []string
+	keysByDigest := make(map[string][]string)
+
+	w := bufio.NewWriter(lines)
+	encoder := json.NewEncoder(w)
+
+	for _, key := range keys {
+		doc, err := db.Documents.Select(ctx, key)
+
+		// Deleted since
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", key, err)
+		}
+
+		err = encoder.Encode(&archive.Document{
+			Key:                doc.Key,
+			Title:              doc.Title,
+			Author:             doc.Author,
+			Language:           doc.Language,
+			LanguageConfidence: doc.LanguageConfidence,
+			Date:               doc.Date,
+			Views:              doc.Views,
+			Digest:             doc.Digest,
+			Length:             doc.Length,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := keysByDigest[doc.Digest]; !ok {
+			digests = append(digests, doc.Digest)
+		}
+
+		keysByDigest[doc.Digest] = append(keysByDigest[doc.Digest], doc.Key)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	size, err := lines.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lines.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	count := 0
+
+	for _, docKeys := range keysByDigest {
+		count += len(docKeys)
+	}
+
+	ar := archive.NewWriter(out)
+
+	// The manifest comes first: documents deleted from now on are counted
+	// still, and reported as they're found missing
+	if err := ar.WriteManifest(count); err != nil {
+		return err
+	}
+
+	if err := ar.WriteDocuments(lines, size); err != nil {
+		return err
+	}
+
+	exported := count
+
+	for _, digest := range digests {
+		ok, err := exportContent(ctx, db, ar, digest, keysByDigest[digest])
+		if err != nil {
+			return fmt.Errorf("exporting content %s: %w", digest, err)
+		}
+
+		// Every document having it was deleted meanwhile, their import is skipped
+		if !ok {
+			for _, key := range keysByDigest[digest] {
+				fmt.Fprintf(os.Stderr, "%s skipped, deleted while being exported\n", key)
+			}
+
+			exported -= len(keysByDigest[digest])
+		}
+	}
+
+	if err := ar.Close(); err != nil {
+		return err
+	}
+
+	// The summary mustn't end up in the export
+	fmt.Fprintf(os.Stderr, "%d document(s) exported, %d distinct content(s)\n", exported, len(digests))
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	if file != nil {
+		return file.Close()
+	}
+
+	return nil
+}
+
+// Archive a content, read through any document still having it. ok is false
+// if there's none left.
+func exportContent(ctx context.Context, db *database.Database, ar *archive.Writer, digest string, keys []string) (ok bool, err error) {
+	for _, key := range keys {
+		doc, err := db.Documents.Select(ctx, key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		content, err := db.Documents.Open(ctx, doc)
+		if err != nil {
+			return false, err
+		}
+
+		err = ar.WriteContent(digest, content, int64(doc.Length))
+		content.Close()
+
+		return err == nil, err
+	}
+
+	return false, nil
+}
+
+// Import an archive, keeping the keys of documents. Documents which exist
+// already are skipped, so that importing twice is harmless.
+func importDocuments(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	from := flags.String("from", "", "")
+	keys := flags.String("keys", "", "")
+	dryRun := flags.Bool("dry-run", false, "")
+
+	if err := parseFlags(flags, args); err != nil || flags.NArg() > 1 {
+		return errUsage
+	}
+
+	args = flags.Args()
+
+	if *from != "" {
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		return importPastes(cfg, *from, args[0], &importer.Options{Keys: *keys}, *dryRun)
+	}
+
+	if *keys != "" || *dryRun {
+		return errUsage
+	}
+
+	var in io.Reader = os.Stdin
+
+	if len(args) == 1 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		in = file
+	}
+
+	ar, err := archive.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	docs, err := ar.Documents()
+	if err != nil {
+		return err
+	}
+
+	byDigest := make(map[string][]*archive.Document)
+
+	for _, doc := range docs {
+		byDigest[doc.Digest] = append(byDigest[doc.Digest], doc)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	created, skipped := 0, 0
+
+	for {
+		digest, content, size, err := ar.NextContent()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		spool, err := storage.NewSpool(cfg.Storage.TempPath, content, size)
+		if err != nil {
+			return err
+		}
+
+		if spool.Digest != digest {
+			spool.Close()
+			return fmt.Errorf("content %s is corrupted", digest)
+		}
+
+		for _, doc := range byDigest[digest] {
+			ok, err := db.Documents.RestoreSpool(ctx, &database.Document{
+				Key:                doc.Key,
+				Title:              doc.Title,
+				Author:             doc.Author,
+				Language:           doc.Language,
+				LanguageConfidence: doc.LanguageConfidence,
+				Date:               doc.Date,
+				Views:              doc.Views,
+			}, spool)
+
+			if err != nil {
+				spool.Close()
+				return fmt.Errorf("importing %s: %w", doc.Key, err)
+			}
+
+			if ok {
+				created++
+			} else {
+				skipped++
+			}
+		}
+
+		delete(byDigest, digest)
+		spool.Close()
+	}
+
+	fmt.Printf("%d document(s) imported, %d existing skipped\n", created, skipped)
+
+	// Deleted while being exported
+	for _, docs := range byDigest {
+		for _, doc := range docs {
+			fmt.Fprintf(os.Stderr, "%s skipped, its content is missing from the archive\n", doc.Key)
+		}
+	}
+
+	return nil
+}
+
+// Import the pastes of another pastebin, keeping their keys. Pastes nekobin
+// can't hold at all, and features it can't carry over, are reported. A dry run
+// reports those along with keys in use already, without importing anything.
+func importPastes(cfg *config.Config, format, path string, opts *importer.Options, dryRun bool) error {
+	src, err := importer.Open(format, path, opts)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	created, conflicts, unreadable, invalid := 0, 0, 0, 0
+
+	for {
+		paste, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := paste.Key
+
+		if name == "" {
+			name = "(no key)"
+		}
+
+		if paste.Unreadable != "" {
+			fmt.Printf("%s: skipped, %s\n", name, paste.Unreadable)
+			unreadable++
+			continue
+		}
+
+		if len(paste.Dropped) > 0 {
+			fmt.Printf("%s: dropped %s\n", name, strings.Join(paste.Dropped, ", "))
+		}
+
+		date := paste.Date
+
+		if date.IsZero() {
+			date = time.Now()
+		}
+
+		doc := &database.Document{
+			Key:                paste.Key,
+			Title:              paste.Title,
+			Author:             paste.Author,
+			Language:           paste.Language,
+			LanguageConfidence: paste.LanguageConfidence,
+			Date:               int(date.Unix()),
+			Views:              paste.Views,
+			Content:            paste.Content,
+		}
+
+		if errResponse := handlers.CheckDocument(cfg, doc); errResponse != nil {
+			fmt.Printf("%s: skipped, invalid: %s\n", name, errResponse.Error)
+			invalid++
+			continue
+		}
+
+		// Unreachable or shadowed by a route as it is, it gets a new one
+		if doc.Key != "" && !handlers.ValidKey(doc.Key) {
+			doc.Key = ""
+		}
+
+		ok := true
+
+		if dryRun {
+			if doc.Key == "" {
+				fmt.Printf("%s: would get a new key\n", name)
+			} else {
+				exists, err := db.Documents.Exists(ctx, doc.Key)
+				if err != nil {
+					return err
+				}
+
+				ok = !exists
+			}
+		} else {
+			ok, err = db.Documents.Restore(ctx, doc)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", name, err)
+			}
+
+			if doc.Key != paste.Key {
+				fmt.Printf("%s: imported as %s\n", name, doc.Key)
+			}
+		}
+
+		if !ok {
+			fmt.Printf("%s: skipped, the key is in use\n", name)
+			conflicts++
+			continue
+		}
+
+		created++
+	}
+
+	verb := "imported"
+
+	if dryRun {
+		verb = "would be imported"
+	}
+
+	fmt.Printf(
+		"%d paste(s) %s, %d skipped as their key is in use, %d unreadable, %d invalid\n",
+		created, verb, conflicts, unreadable, invalid,
+	)
+
+	return nil
+}
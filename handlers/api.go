@@ -27,12 +27,16 @@ package handlers
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/nekobin/nekobin/config"
 	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/languages"
 	"github.com/nekobin/nekobin/response"
+	"github.com/nekobin/nekobin/storage"
+	"github.com/nekobin/nekobin/tracing"
 )
 
 func GetAbout(ctx echo.Context) error {
@@ -46,8 +50,8 @@ func GetAbout(ctx echo.Context) error {
 
 func GetDocument(ctx echo.Context) error {
 	db := ctx.Get("db").(*database.Database)
-	key := strings.Split(ctx.Param("key"), ".")[0]
-	doc, err := db.Documents.Select(key)
+	key, _ := splitKey(ctx.Param("key"))
+	doc, err := db.Documents.Select(ctx.Request().Context(), key)
 
 	if err != nil {
 		return ctx.JSON(
@@ -56,7 +60,20 @@ func GetDocument(ctx echo.Context) error {
 		)
 	}
 
-	go db.Documents.IncrementViews(key, ctx.RealIP())
+	db.Documents.IncrementViews(ctx.Request().Context(), key, ctx.RealIP())
+
+	cfg := ctx.Get("cfg").(*config.Config)
+
+	// The representation includes the views count, which changes over time
+	// while the document date does not: it can't tell whether it's modified
+	setCacheHeaders(ctx, documentETag(doc, doc.Views), time.Time{}, cfg.Cache.API)
+
+	if isNotModified(ctx) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	_, span := tracing.Start(ctx.Request().Context(), "encode response")
+	defer span.End()
 
 	return ctx.JSON(
 		http.StatusOK,
@@ -65,6 +82,11 @@ func GetDocument(ctx echo.Context) error {
 }
 
 func PostDocument(ctx echo.Context) error {
+	switch contentType := ctx.Request().Header.Get(echo.HeaderContentType); {
+	case strings.HasPrefix(contentType, echo.MIMETextPlain), strings.HasPrefix(contentType, echo.MIMEOctetStream):
+		return postRawDocument(ctx)
+	}
+
 	doc := &database.Document{}
 
 	if err := ctx.Bind(doc); err != nil {
@@ -74,32 +96,16 @@ func PostDocument(ctx echo.Context) error {
 		)
 	}
 
-	title, author, content := doc.Title, doc.Author, doc.Content
-
+	content := doc.Content
 	cfg := ctx.Get("cfg").(*config.Config)
 
-	if title != nil {
-		switch length := len(*title); {
-		case length == 0:
-			title = nil
-		case length > cfg.Nekobin.MaxTitleLength:
-			return ctx.JSON(
-				http.StatusBadRequest,
-				response.ErrorTitleTooLong,
-			)
-		}
-	}
+	title, author, errResponse := checkMetadata(cfg, doc.Title, doc.Author)
 
-	if author != nil {
-		switch length := len(*author); {
-		case length == 0:
-			author = nil
-		case length > cfg.Nekobin.MaxAuthorLength:
-			return ctx.JSON(
-				http.StatusBadRequest,
-				response.ErrorAuthorTooLong,
-			)
-		}
+	if errResponse != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			errResponse,
+		)
 	}
 
 	if len(content) == 0 {
@@ -116,14 +122,102 @@ func PostDocument(ctx echo.Context) error {
 		)
 	}
 
+	if !database.IsText(content) {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorContentNotText,
+		)
+	}
+
+	language, confidence, errResponse := checkLanguage(doc.Language, title, content)
+
+	if errResponse != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			errResponse,
+		)
+	}
+
+	db := ctx.Get("db").(*database.Database)
+	doc, err := db.Documents.Insert(ctx.Request().Context(), title, author, language, confidence, content)
+
+	if err != nil {
+		return err
+	}
+
+	db.Documents.IncrementViews(ctx.Request().Context(), doc.Key, ctx.RealIP())
+
+	return ctx.JSON(
+		http.StatusCreated,
+		response.NewResult(doc),
+	)
+}
+
+// Documents sent as raw request bodies are streamed instead of being read in
+// memory, and may be much larger. Their metadata is given as query parameters.
+func postRawDocument(ctx echo.Context) error {
+	cfg := ctx.Get("cfg").(*config.Config)
+
+	title, author, errResponse := checkMetadata(cfg, queryOptional(ctx, "title"), queryOptional(ctx, "author"))
+
+	if errResponse != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			errResponse,
+		)
+	}
+
+	spool, err := storage.NewSpool(cfg.Storage.TempPath, ctx.Request().Body, cfg.Storage.MaxDocumentSize)
+
+	if err == storage.ErrTooLarge {
+		return ctx.JSON(
+			http.StatusRequestEntityTooLarge,
+			response.ErrorContentTooLong,
+		)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := spool.Close(); err != nil {
+			requestLogger(ctx).Error("removing spool", "error", err)
+		}
+	}()
+
+	if spool.Size == 0 {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorContentEmpty,
+		)
+	}
+
+	language, confidence, errResponse := checkLanguage(queryOptional(ctx, "language"), title, string(spool.Head))
+
+	if errResponse != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			errResponse,
+		)
+	}
+
 	db := ctx.Get("db").(*database.Database)
-	doc, err := db.Documents.Insert(title, author, content)
+	doc, err := db.Documents.InsertSpool(ctx.Request().Context(), title, author, language, confidence, spool)
+
+	// Only contents kept in the store may be binary
+	if err == database.ErrNotText {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorContentNotText,
+		)
+	}
 
 	if err != nil {
 		return err
 	}
 
-	go db.Documents.IncrementViews(doc.Key, ctx.RealIP())
+	db.Documents.IncrementViews(ctx.Request().Context(), doc.Key, ctx.RealIP())
 
 	return ctx.JSON(
 		http.StatusCreated,
@@ -131,6 +225,78 @@ func PostDocument(ctx echo.Context) error {
 	)
 }
 
+// Validate the optional title and author of a new document. Empty ones are dropped.
+func checkMetadata(cfg *config.Config, title, author *string) (*string, *string, *response.Error) {
+	if title != nil {
+		switch length := len(*title); {
+		case length == 0:
+			title = nil
+		case length > cfg.Nekobin.MaxTitleLength:
+			return nil, nil, response.ErrorTitleTooLong
+		}
+	}
+
+	if author != nil {
+		switch length := len(*author); {
+		case length == 0:
+			author = nil
+		case length > cfg.Nekobin.MaxAuthorLength:
+			return nil, nil, response.ErrorAuthorTooLong
+		}
+	}
+
+	return title, author, nil
+}
+
+// Validate the language of a new document, guessing it from the content when
+// not given, using the title as file name hint.
+func checkLanguage(language, title *string, content string) (*string, float64, *response.Error) {
+	if language != nil && *language != "" {
+		lang := languages.Lookup(*language)
+
+		if lang == nil {
+			return nil, 0, response.ErrorInvalidLanguage
+		}
+
+		return &lang.Name, 1, nil
+	}
+
+	hint := ""
+
+	if title != nil {
+		hint = *title
+	}
+
+	if lang, confidence := languages.Detect(content, hint); lang != nil {
+		return &lang.Name, confidence, nil
+	}
+
+	return nil, 0, nil
+}
+
+// An optional query parameter, nil when missing.
+func queryOptional(ctx echo.Context, name string) *string {
+	if values, ok := ctx.QueryParams()[name]; ok && len(values) > 0 {
+		return &values[0]
+	}
+
+	return nil
+}
+
+func GetStats(ctx echo.Context) error {
+	db := ctx.Get("db").(*database.Database)
+	stats := echo.Map{"cache": nil}
+
+	if db.Cache != nil {
+		stats["cache"] = db.Cache.Stats()
+	}
+
+	return ctx.JSON(
+		http.StatusOK,
+		response.NewResult(stats),
+	)
+}
+
 func Pong(ctx echo.Context) error {
 	return ctx.JSON(
 		http.StatusOK,
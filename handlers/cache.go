@@ -0,0 +1,103 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/database"
+)
+
+// Strong entity tag of a document representation. Documents never change once
+// saved, so the content digest identifies them; variant tells apart different
+// representations of the same document (line ranges, views count...).
+func documentETag(doc *database.Document, variant ...interface{}) string {
+	digest := doc.Digest
+
+	if digest == "" {
+		digest = database.Digest(doc.Content)
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(digest))
+
+	for _, v := range variant {
+		fmt.Fprintf(hash, "\x00%v", v)
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// Set the caching headers of a response. Last-Modified is left out if modified
+// is zero, then If-Modified-Since is never answered with 304.
+func setCacheHeaders(ctx echo.Context, etag string, modified time.Time, cacheControl string) {
+	header := ctx.Response().Header()
+
+	header.Set("ETag", etag)
+
+	if !modified.IsZero() {
+		header.Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	if cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+}
+
+// Report whether the client copy of a response is still fresh, according to
+// the caching headers already set. If-None-Match wins over If-Modified-Since.
+func isNotModified(ctx echo.Context) bool {
+	req, header := ctx.Request(), ctx.Response().Header()
+
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		etag := header.Get("ETag")
+
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(req.Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(header.Get(echo.HeaderLastModified))
+
+	return err == nil && !modified.After(since)
+}
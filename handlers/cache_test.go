@@ -0,0 +1,142 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+)
+
+func TestIsNotModified(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	before, after := modified.Add(-time.Hour).Format(http.TimeFormat), modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name            string
+		modified        time.Time
+		ifNoneMatch     string
+		ifModifiedSince string
+		expected        bool
+	}{
+		{"nothing", modified, "", "", false},
+		{"same tag", modified, `"tag"`, "", true},
+		{"weak tag", modified, `W/"tag"`, "", true},
+		{"any tag", modified, "*", "", true},
+		{"other tag", modified, `"other"`, "", false},
+		{"one of the tags", modified, `"other", "tag"`, "", true},
+		{"tags win", modified, `"other"`, after, false},
+		{"not modified since", modified, "", after, true},
+		{"modified since", modified, "", before, false},
+		{"no modification date", time.Time{}, "", after, false},
+	}
+
+	e := echo.New()
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+
+		if test.ifModifiedSince != "" {
+			req.Header.Set(echo.HeaderIfModifiedSince, test.ifModifiedSince)
+		}
+
+		ctx := e.NewContext(req, httptest.NewRecorder())
+		setCacheHeaders(ctx, `"tag"`, test.modified, "")
+
+		if notModified := isNotModified(ctx); notModified != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, notModified, test.expected)
+		}
+	}
+}
+
+type viewedDocuments struct {
+	database.DocumentsQuery
+
+	doc *database.Document
+}
+
+func (v *viewedDocuments) Select(ctx context.Context, key string) (*database.Document, error) {
+	copied := *v.doc
+	return &copied, nil
+}
+
+func (v *viewedDocuments) IncrementViews(ctx context.Context, key, ip string) {
+	v.doc.Views++
+}
+
+// The views count changes the API response while the date of the document does not
+func TestGetDocumentRevalidation(t *testing.T) {
+	docs := &viewedDocuments{doc: &database.Document{Key: "key", Content: "content", Date: 1577836800}}
+	cfg := &config.Config{}
+	cfg.Cache.API = "no-cache"
+
+	e := echo.New()
+	e.GET("/api/documents/:key", GetDocument, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("cfg", cfg)
+			ctx.Set("db", &database.Database{Documents: docs})
+
+			return next(ctx)
+		}
+	})
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/documents/key", nil)
+
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	first := get("", "")
+
+	if first.Header().Get(echo.HeaderLastModified) != "" {
+		t.Errorf("Last-Modified sent: %q", first.Header().Get(echo.HeaderLastModified))
+	}
+
+	if rec := get(echo.HeaderIfModifiedSince, time.Now().UTC().Format(http.TimeFormat)); rec.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since: got %d, expected %d", rec.Code, http.StatusOK)
+	}
+
+	if rec := get("If-None-Match", first.Header().Get("ETag")); rec.Code != http.StatusOK {
+		t.Errorf("If-None-Match after new views: got %d, expected %d", rec.Code, http.StatusOK)
+	}
+}
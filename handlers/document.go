@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/languages"
+	"github.com/nekobin/nekobin/logger"
+	"github.com/nekobin/nekobin/render"
+	"github.com/nekobin/nekobin/response"
+)
+
+// Split a key path parameter such as "abcdefghij.py" into the document key
+// and the optional (last) extension that follows it.
+func splitKey(param string) (key, ext string) {
+	key = strings.Split(param, ".")[0]
+
+	if i := strings.LastIndex(param, "."); i >= 0 {
+		ext = param[i+1:]
+	}
+
+	return
+}
+
+// Keys are made of these, so that they can't be mistaken for extensions nor
+// paths
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Names taken by the About document and the routes at the root, documents
+// can't have them as key
+var reservedKeys = map[string]bool{
+	"about":     true,
+	"api":       true,
+	"embed":     true,
+	"healthz":   true,
+	"highlight": true,
+	"img":       true,
+	"md":        true,
+	"metrics":   true,
+	"oembed":    true,
+	"raw":       true,
+	"readyz":    true,
+	"static":    true,
+}
+
+// ValidKey reports whether a document with key would be reachable, such as
+// one imported from elsewhere. Generated keys always are.
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key) && !reservedKeys[strings.ToLower(key)]
+}
+
+// CheckDocument validates a document created other than through the API, such
+// as imported from elsewhere, the way the API validates new ones. Empty titles
+// and authors are dropped. Its key is left to ValidKey.
+func CheckDocument(cfg *config.Config, doc *database.Document) *response.Error {
+	title, author, errResponse := checkMetadata(cfg, doc.Title, doc.Author)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	doc.Title, doc.Author = title, author
+
+	switch length := int64(len(doc.Content)); {
+	case length == 0:
+		return response.ErrorContentEmpty
+	case length > cfg.Storage.MaxDocumentSize:
+		return response.ErrorContentTooLong
+	case !database.IsText(doc.Content):
+		return response.ErrorContentNotText
+	}
+
+	return nil
+}
+
+// Fetch a document. The "about" key refers to the About document, which
+// doesn't live in the database.
+func selectDocument(ctx echo.Context, key string) (*database.Document, error) {
+	if key == "about" {
+		return ctx.Get("about").(*database.Document), nil
+	}
+
+	db := ctx.Get("db").(*database.Database)
+	doc, err := db.Documents.Select(ctx.Request().Context(), key)
+
+	return doc, err
+}
+
+// Fetch a document for viewing, counting the view.
+func viewDocument(ctx echo.Context, key string) (*database.Document, error) {
+	doc, err := selectDocument(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "about" {
+		db := ctx.Get("db").(*database.Database)
+		db.Documents.IncrementViews(ctx.Request().Context(), key, ctx.RealIP())
+	}
+
+	return doc, nil
+}
+
+// The logger of the request, carrying its ID
+func requestLogger(ctx echo.Context) *logger.Logger {
+	if log, ok := ctx.Get("logger").(*logger.Logger); ok {
+		return log
+	}
+
+	return logger.Default
+}
+
+// External documents are too large to be rendered, so they are only served raw.
+func redirectToRaw(ctx echo.Context, key string) error {
+	url := "/raw/" + key
+
+	if query := ctx.QueryString(); query != "" {
+		url += "?" + query
+	}
+
+	return ctx.Redirect(http.StatusFound, url)
+}
+
+// The language of a document: the stored one wins over the one guessed from
+// the URL extension. Returns nil when neither is known.
+func documentLanguage(doc *database.Document, ext string) *languages.Language {
+	if doc.Language != nil {
+		if lang := languages.Get(*doc.Language); lang != nil {
+			return lang
+		}
+	}
+
+	return languages.ByExtension(ext)
+}
+
+// The first few lines of a document, shortened to at most max characters.
+func excerpt(content string, lines, max int) string {
+	parts := strings.SplitN(strings.TrimSpace(content), "\n", lines+1)
+
+	if len(parts) > lines {
+		parts = parts[:lines]
+	}
+
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+
+	text := []rune(strings.Join(parts, " "))
+
+	if len(text) > max {
+		return string(text[:max-1]) + "…"
+	}
+
+	return string(text)
+}
+
+// The URL this instance is reached at, as seen by the client.
+func baseURL(ctx echo.Context) string {
+	return ctx.Scheme() + "://" + ctx.Request().Host
+}
+
+// The theme chosen by the user in the frontend, dark by default.
+func theme(ctx echo.Context) string {
+	if cookie, err := ctx.Cookie("theme"); err == nil && render.IsTheme(cookie.Value) {
+		return cookie.Value
+	}
+
+	return "dark"
+}
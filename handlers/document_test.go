@@ -0,0 +1,91 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := map[string]bool{
+		"abcdefghij": true,
+		"a1_B-2":     true,
+		"":           false,
+		"abc.py":     false,
+		"abc/def":    false,
+		"héllo":      false,
+		"with space": false,
+		"about":      false,
+		"healthz":    false,
+		"oembed":     false,
+		"metrics":    false,
+		"API":        false,
+	}
+
+	for key, expected := range tests {
+		if valid := ValidKey(key); valid != expected {
+			t.Errorf("%q: got %v, expected %v", key, valid, expected)
+		}
+	}
+}
+
+// Binary bodies can't be kept in the database, as text
+func TestPostRawDocumentNotText(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Storage.MaxDocumentSize = 1024
+	docs := &fakeDocuments{docs: make(map[string]string)}
+
+	e := echo.New()
+	e.POST("/api/documents", PostDocument, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("cfg", cfg)
+			ctx.Set("db", &database.Database{Documents: docs})
+
+			return next(ctx)
+		}
+	})
+
+	tests := map[string]int{
+		"text":             http.StatusCreated,
+		"nul\x00":          http.StatusBadRequest,
+		"invalid \xff\xfe": http.StatusBadRequest,
+	}
+
+	for body, expected := range tests {
+		rec := serve(e, http.MethodPost, "/api/documents", map[string]string{
+			echo.HeaderContentType: echo.MIMEOctetStream,
+		}, body)
+
+		if rec.Code != expected {
+			t.Errorf("%q: got %d, expected %d", body, rec.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/render"
+	"github.com/nekobin/nekobin/response"
+)
+
+// Script for /embed/:key.js, writing an auto-sized frame right after itself.
+const embedScript = `(function () {
+  var script = document.currentScript
+  var frame = document.createElement("iframe")
+
+  frame.src = %s
+  frame.title = %s
+  frame.loading = "lazy"
+  frame.style.width = "100%%"
+  frame.style.border = "0"
+
+  script.parentNode.insertBefore(frame, script.nextSibling)
+
+  window.addEventListener("message", function (event) {
+    if (event.source === frame.contentWindow && event.data && event.data.nekobin) {
+      frame.style.height = event.data.nekobin.height + "px"
+    }
+  })
+})()
+`
+
+func GetEmbed(ctx echo.Context) error {
+	param := ctx.Param("key")
+
+	if strings.HasSuffix(param, ".js") {
+		return getEmbedScript(ctx, strings.TrimSuffix(param, ".js"))
+	}
+
+	key, ext := splitKey(param)
+	doc, err := viewDocument(ctx, key)
+
+	if err != nil {
+		return ctx.String(
+			http.StatusNotFound,
+			response.ErrorDocumentNotFound.Error,
+		)
+	}
+
+	if doc.External {
+		return redirectToRaw(ctx, param)
+	}
+
+	opts := render.HighlightOptions{LineNumbers: true}
+	content := doc.Content
+
+	if lines := ctx.QueryParam("lines"); lines != "" {
+		r, err := parseLineRange(lines)
+
+		if err != nil {
+			return ctx.String(
+				http.StatusBadRequest,
+				response.ErrorInvalidLineRange.Error,
+			)
+		}
+
+		content = r.Extract(content)
+		opts.FirstLine = r.First
+	}
+
+	switch ctx.QueryParam("linenos") {
+	case "0", "false", "no":
+		opts.LineNumbers = false
+	}
+
+	language := ""
+
+	if lang := documentLanguage(doc, ext); lang != nil {
+		language = lang.Name
+	}
+
+	highlighted, err := render.Highlight(content, language, opts)
+
+	if err != nil {
+		return err
+	}
+
+	embedTheme := ctx.QueryParam("theme")
+
+	if !render.IsTheme(embedTheme) {
+		embedTheme = theme(ctx)
+	}
+
+	setEmbedHeaders(ctx)
+
+	return ctx.Render(
+		http.StatusOK,
+		"embed.html",
+		echo.Map{
+			"theme":       embedTheme,
+			"document":    doc,
+			"highlighted": highlighted,
+			"url":         baseURL(ctx) + "/" + param,
+		},
+	)
+}
+
+func getEmbedScript(ctx echo.Context, param string) error {
+	key, _ := splitKey(param)
+
+	if _, err := selectDocument(ctx, key); err != nil {
+		return ctx.String(
+			http.StatusNotFound,
+			"/* "+response.ErrorDocumentNotFound.Error+" */",
+		)
+	}
+
+	src := baseURL(ctx) + "/embed/" + param
+
+	// Options given to the script apply to the frame
+	if query := ctx.QueryString(); query != "" {
+		src += "?" + query
+	}
+
+	srcJSON, _ := json.Marshal(src)
+	titleJSON, _ := json.Marshal("nekobin - " + key)
+
+	setEmbedHeaders(ctx)
+
+	return ctx.Blob(
+		http.StatusOK,
+		echo.MIMEApplicationJavaScriptCharsetUTF8,
+		[]byte(fmt.Sprintf(embedScript, srcJSON, titleJSON)),
+	)
+}
+
+func setEmbedHeaders(ctx echo.Context) {
+	cfg := ctx.Get("cfg").(*config.Config)
+	ancestors := cfg.Embed.FrameAncestors
+
+	if len(ancestors) == 0 {
+		ancestors = []string{"*"}
+	}
+
+	header := ctx.Response().Header()
+
+	header.Set(echo.HeaderContentSecurityPolicy, "frame-ancestors "+strings.Join(ancestors, " "))
+	// Embedded snippets are always fetched fresh, so that they stay up to date
+	header.Set("Cache-Control", "no-cache")
+}
@@ -0,0 +1,124 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/health"
+	"github.com/nekobin/nekobin/response"
+)
+
+type checkResult struct {
+	Ok      bool    `json:"ok"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Liveness: the process is up and serving requests
+func GetHealthz(ctx echo.Context) error {
+	return ctx.JSON(
+		http.StatusOK,
+		response.NewResult(echo.Map{"status": "alive"}),
+	)
+}
+
+// Readiness: the instance can serve documents and isn't shutting down
+func GetReadyz(ctx echo.Context) error {
+	cfg := ctx.Get("cfg").(*config.Config)
+	db := ctx.Get("db").(*database.Database)
+	state := ctx.Get("health").(*health.State)
+
+	checks := map[string]*checkResult{
+		"database": runCheck(ctx.Request().Context(), cfg.Health.Timeout, db.Ping),
+		"draining": {Ok: !state.Draining()},
+	}
+
+	if store := db.Store(); store != nil {
+		checks["storage"] = runCheck(ctx.Request().Context(), cfg.Health.Timeout, store.Ping)
+	}
+
+	ready := true
+	status := http.StatusOK
+
+	for _, check := range checks {
+		if !check.Ok {
+			ready = false
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	result := echo.Map{"status": "ready", "checks": checks}
+
+	if !ready {
+		result["status"] = "not ready"
+	}
+
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+
+	return ctx.JSON(
+		status,
+		&response.Result{Ok: ready, Result: result},
+	)
+}
+
+// Run a check, giving up after timeout. Checks ignoring their context are
+// left running in the background.
+func runCheck(parent context.Context, timeout time.Duration, check func(ctx context.Context) error) *checkResult {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := &checkResult{
+		Ok:      err == nil,
+		Latency: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
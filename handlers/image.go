@@ -0,0 +1,101 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/render"
+	"github.com/nekobin/nekobin/response"
+)
+
+func GetDocumentImage(ctx echo.Context) error {
+	key, _ := splitKey(strings.TrimSuffix(ctx.Param("key"), ".png"))
+	doc, err := viewDocument(ctx, key)
+
+	if err != nil {
+		return ctx.JSON(
+			http.StatusNotFound,
+			response.ErrorDocumentNotFound,
+		)
+	}
+
+	if doc.External {
+		return ctx.JSON(
+			http.StatusRequestEntityTooLarge,
+			response.ErrorDocumentTooLarge,
+		)
+	}
+
+	cfg := ctx.Get("cfg").(*config.Config)
+	opts := render.ImageOptions{
+		Theme:      ctx.QueryParam("theme"),
+		MaxLines:   cfg.Image.MaxLines,
+		MaxColumns: cfg.Image.MaxColumns,
+	}
+
+	if !render.IsTheme(opts.Theme) {
+		opts.Theme = theme(ctx)
+	}
+
+	content := doc.Content
+	lines := ctx.QueryParam("lines")
+
+	if lines != "" {
+		r, err := parseLineRange(lines)
+
+		if err != nil {
+			return ctx.JSON(
+				http.StatusBadRequest,
+				response.ErrorInvalidLineRange,
+			)
+		}
+
+		content = r.Extract(content)
+		opts.FirstLine = r.First
+	}
+
+	// The theme may come from the cookie
+	ctx.Response().Header().Set(echo.HeaderVary, "Cookie")
+	setCacheHeaders(ctx, documentETag(doc, "png", lines, opts.Theme), time.Unix(int64(doc.Date), 0), cfg.Cache.Documents)
+
+	if isNotModified(ctx) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := render.Image(buf, content, opts); err != nil {
+		return err
+	}
+
+	return ctx.Blob(http.StatusOK, "image/png", buf.Bytes())
+}
@@ -0,0 +1,143 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errInvalidLineRange = errors.New("invalid line range")
+
+// A range of lines, 1-based and inclusive. Last is 0 when open-ended.
+type lineRange struct {
+	First int
+	Last  int
+}
+
+// Parse line ranges such as "120-180", "120-" or "120". Both "L120-L180" and
+// "120:180" forms, as found in URL fragments and editors, are accepted too.
+func parseLineRange(s string) (*lineRange, error) {
+	s = strings.ToUpper(s)
+	from, to, isRange := s, "", false
+
+	if i := strings.IndexAny(s, "-:"); i >= 0 {
+		from, to, isRange = s[:i], s[i+1:], true
+	}
+
+	first, err := parseLineNumber(from)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &lineRange{First: first, Last: first}
+
+	switch {
+	case !isRange:
+	case to == "":
+		r.Last = 0
+	default:
+		last, err := parseLineNumber(to)
+		if err != nil || last < first {
+			return nil, errInvalidLineRange
+		}
+
+		r.Last = last
+	}
+
+	return r, nil
+}
+
+// Parse a line number such as "120" or "L120"
+func parseLineNumber(s string) (int, error) {
+	s = strings.TrimPrefix(s, "L")
+
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, errInvalidLineRange
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errInvalidLineRange
+	}
+
+	return n, nil
+}
+
+// Extract the lines in range from content. Lines past the end are ignored.
+func (r *lineRange) Extract(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+
+	if r.First > len(lines) {
+		return ""
+	}
+
+	last := r.Last
+
+	if last == 0 || last > len(lines) {
+		last = len(lines)
+	}
+
+	return strings.Join(lines[r.First-1:last], "")
+}
+
+// Copy the lines in range from r to w, reading no further than needed.
+func (r *lineRange) Copy(w io.Writer, src io.Reader) error {
+	reader := bufio.NewReader(src)
+
+	for n := 1; r.Last == 0 || n <= r.Last; n++ {
+		line, err := reader.ReadSlice('\n')
+
+		// Lines longer than the buffer come in several pieces
+		for err == bufio.ErrBufferFull {
+			if n >= r.First {
+				if _, err := w.Write(line); err != nil {
+					return err
+				}
+			}
+
+			line, err = reader.ReadSlice('\n')
+		}
+
+		if n >= r.First && len(line) > 0 {
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
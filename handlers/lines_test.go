@@ -0,0 +1,93 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import "testing"
+
+func TestParseLineRange(t *testing.T) {
+	tests := map[string]*lineRange{
+		"120":                  {First: 120, Last: 120},
+		"120-180":              {First: 120, Last: 180},
+		"120-":                 {First: 120, Last: 0},
+		"L120-L180":            {First: 120, Last: 180},
+		"l120-l180":            {First: 120, Last: 180},
+		"L120-180":             {First: 120, Last: 180},
+		"120:180":              {First: 120, Last: 180},
+		"120:":                 {First: 120, Last: 0},
+		"5-5":                  {First: 5, Last: 5},
+		"":                     nil,
+		"-":                    nil,
+		"-5":                   nil,
+		":5":                   nil,
+		"0":                    nil,
+		"0-5":                  nil,
+		"5-4":                  nil,
+		"5--7":                 nil,
+		"5-7-9":                nil,
+		"5-+7":                 nil,
+		"+5":                   nil,
+		"1L2":                  nil,
+		"LL5":                  nil,
+		"five":                 nil,
+		"99999999999999999999": nil,
+	}
+
+	for s, expected := range tests {
+		r, err := parseLineRange(s)
+
+		if expected == nil {
+			if err == nil {
+				t.Errorf("%q: got %+v, expected an error", s, r)
+			}
+
+			continue
+		}
+
+		if err != nil || *r != *expected {
+			t.Errorf("%q: got %+v (%v), expected %+v", s, r, err, expected)
+		}
+	}
+}
+
+func TestLineRangeExtract(t *testing.T) {
+	content := "one\ntwo\nthree\n"
+
+	tests := []struct {
+		r        lineRange
+		expected string
+	}{
+		{lineRange{First: 1, Last: 1}, "one\n"},
+		{lineRange{First: 2, Last: 3}, "two\nthree\n"},
+		{lineRange{First: 2, Last: 0}, "two\nthree\n"},
+		{lineRange{First: 3, Last: 10}, "three\n"},
+		{lineRange{First: 10, Last: 0}, ""},
+	}
+
+	for _, test := range tests {
+		if extracted := test.r.Extract(content); extracted != test.expected {
+			t.Errorf("%+v: got %q, expected %q", test.r, extracted, test.expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/render"
+)
+
+func GetMarkdownDocument(ctx echo.Context) error {
+	key, _ := splitKey(ctx.Param("key"))
+	doc, err := viewDocument(ctx, key)
+
+	if err != nil {
+		return ctx.Render(
+			http.StatusNotFound,
+			"app.html",
+			echo.Map{
+				"year":  time.Now().Year(),
+				"theme": theme(ctx),
+			},
+		)
+	}
+
+	if doc.External {
+		return redirectToRaw(ctx, ctx.Param("key"))
+	}
+
+	rendered, err := render.Markdown(doc.Content)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.Render(
+		http.StatusOK,
+		"markdown.html",
+		echo.Map{
+			"year":     time.Now().Year(),
+			"theme":    theme(ctx),
+			"document": doc,
+			"rendered": rendered,
+		},
+	)
+}
@@ -0,0 +1,130 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/response"
+)
+
+// oEmbed response, see https://oembed.com
+type oEmbed struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	CacheAge     int    `json:"cache_age,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+const (
+	oEmbedWidth      = 640
+	oEmbedMaxHeight  = 480
+	oEmbedLineHeight = 20
+	// Room for the frame border and the footer
+	oEmbedExtraHeight = 40
+)
+
+func GetOEmbed(ctx echo.Context) error {
+	if format := ctx.QueryParam("format"); format != "" && format != "json" {
+		return ctx.JSON(
+			http.StatusNotImplemented,
+			response.ErrorInvalidData,
+		)
+	}
+
+	u, err := url.Parse(ctx.QueryParam("url"))
+
+	// Only links to this very instance can be embedded
+	if err != nil || u.Host != ctx.Request().Host {
+		return ctx.JSON(
+			http.StatusNotFound,
+			response.ErrorDocumentNotFound,
+		)
+	}
+
+	// Any of /:key, /raw/:key, /md/:key...
+	key, _ := splitKey(path.Base(strings.TrimSuffix(u.Path, "/")))
+	doc, err := selectDocument(ctx, key)
+
+	if err != nil {
+		return ctx.JSON(
+			http.StatusNotFound,
+			response.ErrorDocumentNotFound,
+		)
+	}
+
+	width := boundedQueryInt(ctx, "maxwidth", oEmbedWidth)
+	height := strings.Count(doc.Content, "\n")*oEmbedLineHeight + oEmbedExtraHeight
+
+	if maxHeight := boundedQueryInt(ctx, "maxheight", oEmbedMaxHeight); height > maxHeight {
+		height = maxHeight
+	}
+
+	embed := &oEmbed{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        newMeta(ctx, doc).Title,
+		ProviderName: "nekobin",
+		ProviderURL:  baseURL(ctx) + "/",
+		CacheAge:     3600,
+		HTML: fmt.Sprintf(
+			`<iframe src="%s" width="%d" height="%d" style="border: 0" loading="lazy"></iframe>`,
+			html.EscapeString(baseURL(ctx)+"/embed/"+doc.Key), width, height,
+		),
+		Width:  width,
+		Height: height,
+	}
+
+	if doc.Author != nil {
+		embed.AuthorName = *doc.Author
+	}
+
+	return ctx.JSON(http.StatusOK, embed)
+}
+
+// An integer query parameter, no larger than def, which is also its default.
+func boundedQueryInt(ctx echo.Context, name string, def int) int {
+	n, err := strconv.Atoi(ctx.QueryParam(name))
+
+	if err != nil || n <= 0 || n > def {
+		return def
+	}
+
+	return n
+}
@@ -25,20 +25,25 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/nekobin/nekobin/compress"
+	"github.com/nekobin/nekobin/config"
 	"github.com/nekobin/nekobin/database"
 	"github.com/nekobin/nekobin/response"
 )
 
 func GetRawDocument(ctx echo.Context) error {
-	db := ctx.Get("db").(*database.Database)
-	key := strings.Split(ctx.Param("key"), ".")[0]
-	doc, err := db.Documents.Select(key)
+	key, ext := splitKey(ctx.Param("key"))
+	doc, err := viewDocument(ctx, key)
 
 	if err != nil {
 		return ctx.String(
@@ -47,8 +52,6 @@ func GetRawDocument(ctx echo.Context) error {
 		)
 	}
 
-	go db.Documents.IncrementViews(key, ctx.RealIP())
-
 	if doc.Title != nil {
 		ctx.Response().Header().Set("Document-Title", *doc.Title)
 	}
@@ -61,8 +64,113 @@ func GetRawDocument(ctx echo.Context) error {
 	ctx.Response().Header().Set("Document-Views", strconv.Itoa(doc.Views))
 	ctx.Response().Header().Set("Document-length", strconv.Itoa(doc.Length))
 
-	return ctx.String(
-		http.StatusOK,
-		doc.Content,
+	lang := documentLanguage(doc, ext)
+	contentType := echo.MIMETextPlainCharsetUTF8
+
+	if lang != nil {
+		contentType = lang.ContentType()
+		ctx.Response().Header().Set("Document-Language", lang.Name)
+	}
+
+	// Documents may carry any markup: never let browsers run it on our origin.
+	ctx.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	ctx.Response().Header().Set(echo.HeaderContentSecurityPolicy, "sandbox")
+
+	content := doc.Content
+	lines := ctx.QueryParam("lines")
+	var r *lineRange
+
+	if lines != "" {
+		r, err = parseLineRange(lines)
+
+		if err != nil {
+			return ctx.String(
+				http.StatusBadRequest,
+				response.ErrorInvalidLineRange.Error,
+			)
+		}
+
+		if !doc.External {
+			content = r.Extract(content)
+		}
+	} else if sum, err := hex.DecodeString(doc.Digest); err == nil && len(sum) > 0 {
+		// RFC 3230 instance digest
+		ctx.Response().Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+
+	cfg := ctx.Get("cfg").(*config.Config)
+	modified := time.Unix(int64(doc.Date), 0)
+
+	setCacheHeaders(ctx, documentETag(doc, lines), modified, cfg.Cache.Documents)
+	ctx.Response().Header().Set(echo.HeaderContentType, contentType)
+
+	// Send stored compressed documents as they are to clients accepting their encoding
+	if doc.Encoding != nil && lines == "" && ctx.Request().Header.Get("Range") == "" {
+		encoding := *doc.Encoding
+
+		if compress.Negotiate(ctx.Request().Header.Get(echo.HeaderAcceptEncoding), encoding) != "" {
+			// The compress middleware adds the encoding to the entity tag
+			ctx.Response().Header().Set(echo.HeaderContentEncoding, encoding)
+
+			if isNotModified(ctx) {
+				return ctx.NoContent(http.StatusNotModified)
+			}
+
+			return ctx.Blob(http.StatusOK, contentType, doc.Data)
+		}
+	}
+
+	if doc.External {
+		return serveExternalDocument(ctx, doc, r, modified)
+	}
+
+	// Takes care of conditional and Range requests too
+	http.ServeContent(
+		ctx.Response(),
+		ctx.Request(),
+		"",
+		modified,
+		strings.NewReader(content),
 	)
+
+	return nil
+}
+
+// External documents are streamed from the blob store rather than loaded in memory
+func serveExternalDocument(ctx echo.Context, doc *database.Document, r *lineRange, modified time.Time) error {
+	db := ctx.Get("db").(*database.Database)
+	content, err := db.Documents.Open(ctx.Request().Context(), doc)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := content.Close(); err != nil {
+			requestLogger(ctx).Error("closing document content", "key", doc.Key, "error", err)
+		}
+	}()
+
+	if r != nil {
+		if isNotModified(ctx) {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+
+		ctx.Response().WriteHeader(http.StatusOK)
+
+		return r.Copy(ctx.Response(), content)
+	}
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(ctx.Response(), ctx.Request(), "", modified, seeker)
+		return nil
+	}
+
+	if isNotModified(ctx) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	ctx.Response().Header().Set(echo.HeaderContentLength, strconv.Itoa(doc.Length))
+
+	return ctx.Stream(http.StatusOK, ctx.Response().Header().Get(echo.HeaderContentType), content)
 }
@@ -26,17 +26,93 @@ package handlers
 
 import (
 	"net/http"
+	neturl "net/url"
 	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/render"
 )
 
 func GetRoot(ctx echo.Context) error {
-	return ctx.Render(
-		http.StatusOK,
-		"app.html",
-		echo.Map{
-			"year": time.Now().Year(),
-		},
-	)
+	data := echo.Map{
+		"year":  time.Now().Year(),
+		"theme": theme(ctx),
+	}
+
+	if ctx.Param("key") == "" {
+		return ctx.Render(http.StatusOK, "app.html", data)
+	}
+
+	key, ext := splitKey(ctx.Param("key"))
+	doc, err := viewDocument(ctx, key)
+
+	if err != nil {
+		return ctx.Render(http.StatusNotFound, "app.html", data)
+	}
+
+	if doc.External {
+		return redirectToRaw(ctx, ctx.Param("key"))
+	}
+
+	language := ""
+
+	if lang := documentLanguage(doc, ext); lang != nil {
+		language = lang.Name
+	}
+
+	// Render the document server-side, so that it can be read without JavaScript.
+	// The frontend replaces it with the editor when it loads.
+	highlighted, err := render.Highlight(doc.Content, language, render.HighlightOptions{LineNumbers: true})
+
+	if err != nil {
+		return err
+	}
+
+	data["document"] = doc
+	data["path"] = ctx.Request().URL.Path
+	data["highlighted"] = highlighted
+	data["meta"] = newMeta(ctx, doc)
+
+	return ctx.Render(http.StatusOK, "app.html", data)
+}
+
+// Link preview metadata of a document, for OpenGraph, Twitter and oEmbed consumers
+type meta struct {
+	Title       string
+	Description string
+	Date        string
+	URL         string
+	Image       string
+	OEmbed      string
+}
+
+func newMeta(ctx echo.Context, doc *database.Document) *meta {
+	title := doc.Key
+
+	if doc.Title != nil {
+		title = *doc.Title
+	}
+
+	url := baseURL(ctx) + "/" + doc.Key
+
+	return &meta{
+		Title:       title,
+		Description: excerpt(doc.Content, 3, 200),
+		Date:        time.Unix(int64(doc.Date), 0).UTC().Format(time.RFC3339),
+		URL:         url,
+		Image:       baseURL(ctx) + "/img/" + doc.Key + ".png?lines=1-30",
+		OEmbed:      baseURL(ctx) + "/oembed?format=json&url=" + neturl.QueryEscape(url),
+	}
+}
+
+func GetHighlightStylesheet(ctx echo.Context) error {
+	css, ok := render.Stylesheet(ctx.Param("theme"))
+
+	if !ok {
+		return echo.ErrNotFound
+	}
+
+	return ctx.Blob(http.StatusOK, "text/css; charset=UTF-8", []byte(css))
 }
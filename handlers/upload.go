@@ -0,0 +1,361 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/languages"
+	"github.com/nekobin/nekobin/response"
+	"github.com/nekobin/nekobin/storage"
+)
+
+// Resumable uploads, following the tus 1.0 protocol (https://tus.io/protocols/resumable-upload.html)
+// with the creation, expiration and termination extensions.
+
+var errInvalidMetadata = errors.New("invalid upload metadata")
+
+func GetUploadOptions(ctx echo.Context) error {
+	cfg := ctx.Get("cfg").(*config.Config)
+	header := ctx.Response().Header()
+
+	header.Set("Tus-Extension", "creation,expiration,termination")
+	header.Set("Tus-Max-Size", strconv.FormatInt(cfg.Uploads.MaxSize, 10))
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func PostUpload(ctx echo.Context) error {
+	cfg := ctx.Get("cfg").(*config.Config)
+	length, err := strconv.ParseInt(ctx.Request().Header.Get("Upload-Length"), 10, 64)
+
+	if err != nil || length < 0 {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorInvalidData,
+		)
+	}
+
+	if length == 0 {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorContentEmpty,
+		)
+	}
+
+	if length > cfg.Uploads.MaxSize {
+		return ctx.JSON(
+			http.StatusRequestEntityTooLarge,
+			response.ErrorContentTooLong,
+		)
+	}
+
+	metadata, err := parseUploadMetadata(ctx.Request().Header.Get("Upload-Metadata"))
+
+	if err != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorInvalidData,
+		)
+	}
+
+	// Generic tus clients send the file name
+	if metadata["title"] == nil {
+		metadata["title"] = metadata["filename"]
+	}
+
+	title, author, errResponse := checkMetadata(cfg, metadata["title"], metadata["author"])
+
+	if errResponse != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			errResponse,
+		)
+	}
+
+	// The language is guessed from the content once complete, when not given
+	var language *string
+
+	if name := metadata["language"]; name != nil && *name != "" {
+		lang := languages.Lookup(*name)
+
+		if lang == nil {
+			return ctx.JSON(
+				http.StatusBadRequest,
+				response.ErrorInvalidLanguage,
+			)
+		}
+
+		language = &lang.Name
+	}
+
+	db := ctx.Get("db").(*database.Database)
+	upload, err := db.Uploads.Create(ctx.Request().Context(), length, title, author, language, time.Now().Add(cfg.Uploads.Expiration))
+
+	if err != nil {
+		return err
+	}
+
+	setUploadHeaders(ctx, upload)
+	ctx.Response().Header().Set(echo.HeaderLocation, baseURL(ctx)+"/api/uploads/"+upload.ID)
+
+	return ctx.NoContent(http.StatusCreated)
+}
+
+func HeadUpload(ctx echo.Context) error {
+	db := ctx.Get("db").(*database.Database)
+	upload, err := db.Uploads.Select(ctx.Request().Context(), ctx.Param("id"))
+
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+
+	switch err {
+	case nil:
+	case database.ErrUploadNotFound:
+		return ctx.NoContent(http.StatusNotFound)
+	case database.ErrUploadExpired:
+		return ctx.NoContent(http.StatusGone)
+	default:
+		return err
+	}
+
+	setUploadHeaders(ctx, upload)
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+func PatchUpload(ctx echo.Context) error {
+	if ctx.Request().Header.Get(echo.HeaderContentType) != "application/offset+octet-stream" {
+		return ctx.JSON(
+			http.StatusUnsupportedMediaType,
+			response.ErrorInvalidData,
+		)
+	}
+
+	offset, err := strconv.ParseInt(ctx.Request().Header.Get("Upload-Offset"), 10, 64)
+
+	if err != nil {
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorInvalidData,
+		)
+	}
+
+	id := ctx.Param("id")
+	db := ctx.Get("db").(*database.Database)
+
+	lock, err := db.Uploads.Lock(ctx.Request().Context(), id)
+
+	if err != nil {
+		return uploadError(ctx, err)
+	}
+
+	defer unlockUpload(ctx, id, lock)
+
+	upload, err := db.Uploads.Select(ctx.Request().Context(), id)
+
+	if err != nil {
+		return uploadError(ctx, err)
+	}
+
+	if offset != upload.Offset {
+		return ctx.JSON(
+			http.StatusConflict,
+			response.ErrorUploadConflict,
+		)
+	}
+
+	cfg := ctx.Get("cfg").(*config.Config)
+
+	if upload.Offset < upload.Length {
+		err = db.Uploads.Append(ctx.Request().Context(), upload, lock, ctx.Request().Body, time.Now().Add(cfg.Uploads.Expiration))
+	}
+
+	// Whatever was written counts, even if the request failed midway
+	setUploadHeaders(ctx, upload)
+
+	if err != nil {
+		return uploadError(ctx, err)
+	}
+
+	// Completing may have failed before: retrying with an empty chunk tries again
+	if upload.Offset == upload.Length && upload.Document == nil {
+		doc, err := finishUpload(ctx, upload)
+
+		if err != nil {
+			return uploadError(ctx, err)
+		}
+
+		ctx.Response().Header().Set("Document-Key", doc.Key)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func DeleteUpload(ctx echo.Context) error {
+	id := ctx.Param("id")
+	db := ctx.Get("db").(*database.Database)
+
+	lock, err := db.Uploads.Lock(ctx.Request().Context(), id)
+
+	if err != nil {
+		return uploadError(ctx, err)
+	}
+
+	defer unlockUpload(ctx, id, lock)
+
+	if err := db.Uploads.Delete(ctx.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Turn a complete upload into a document. Its content is left in place if that
+// fails, so that it can be tried again.
+func finishUpload(ctx echo.Context, upload *database.Upload) (*database.Document, error) {
+	db := ctx.Get("db").(*database.Database)
+	cfg := ctx.Get("cfg").(*config.Config)
+	spool, err := storage.NewSpool(cfg.Storage.TempPath, db.Uploads.Open(ctx.Request().Context(), upload), upload.Length)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := spool.Close(); err != nil {
+			requestLogger(ctx).Error("removing spool", "id", upload.ID, "error", err)
+		}
+	}()
+
+	language, confidence, _ := checkLanguage(upload.Language, upload.Title, string(spool.Head))
+	doc, err := db.Documents.InsertSpool(ctx.Request().Context(), upload.Title, upload.Author, language, confidence, spool)
+
+	if err == nil {
+		err = db.Uploads.Finish(ctx.Request().Context(), upload, doc.Key)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.Documents.IncrementViews(ctx.Request().Context(), doc.Key, ctx.RealIP())
+
+	return doc, nil
+}
+
+// Respond to the errors of uploads the tus way: unknown uploads are not found,
+// expired ones gone.
+func uploadError(ctx echo.Context, err error) error {
+	switch err {
+	case database.ErrUploadNotFound:
+		return ctx.JSON(
+			http.StatusNotFound,
+			response.ErrorUploadNotFound,
+		)
+	case database.ErrUploadExpired:
+		return ctx.JSON(
+			http.StatusGone,
+			response.ErrorUploadExpired,
+		)
+	case database.ErrUploadLocked:
+		return ctx.JSON(
+			http.StatusLocked,
+			response.ErrorUploadLocked,
+		)
+	case database.ErrUploadConflict:
+		return ctx.JSON(
+			http.StatusConflict,
+			response.ErrorUploadConflict,
+		)
+	case database.ErrNotText:
+		return ctx.JSON(
+			http.StatusBadRequest,
+			response.ErrorContentNotText,
+		)
+	}
+
+	return err
+}
+
+// Unlock an upload, which would otherwise stay locked until the lock expires
+func unlockUpload(ctx echo.Context, id, lock string) {
+	db := ctx.Get("db").(*database.Database)
+
+	if err := db.Uploads.Unlock(ctx.Request().Context(), id, lock); err != nil {
+		requestLogger(ctx).Error("unlocking upload", "id", id, "error", err)
+	}
+}
+
+func setUploadHeaders(ctx echo.Context, upload *database.Upload) {
+	header := ctx.Response().Header()
+
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	header.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	header.Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+
+	if upload.Document != nil {
+		header.Set("Document-Key", *upload.Document)
+	}
+}
+
+// Parse the Upload-Metadata header: comma-separated keys, each followed by
+// an optional base64 encoded value.
+func parseUploadMetadata(header string) (map[string]*string, error) {
+	metadata := make(map[string]*string)
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			value := ""
+			metadata[fields[0]] = &value
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+
+			if err != nil {
+				return nil, errInvalidMetadata
+			}
+
+			decoded := string(value)
+			metadata[fields[0]] = &decoded
+		default:
+			return nil, errInvalidMetadata
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,406 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/storage"
+)
+
+// Uploads kept in memory, with the semantics of database.Uploads
+type fakeUploads struct {
+	uploads map[string]*database.Upload
+	content map[string][]byte
+	locks   map[string]string
+	mu      sync.Mutex
+	next    int
+}
+
+func newFakeUploads() *fakeUploads {
+	return &fakeUploads{
+		uploads: make(map[string]*database.Upload),
+		content: make(map[string][]byte),
+		locks:   make(map[string]string),
+	}
+}
+
+func (f *fakeUploads) Create(ctx context.Context, length int64, title, author, language *string, expires time.Time) (*database.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	upload := &database.Upload{
+		ID:       "upload" + strconv.Itoa(f.next),
+		Length:   length,
+		Title:    title,
+		Author:   author,
+		Language: language,
+		Expires:  expires,
+	}
+	f.uploads[upload.ID] = upload
+
+	copied := *upload
+
+	return &copied, nil
+}
+
+func (f *fakeUploads) Select(ctx context.Context, id string) (*database.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, ok := f.uploads[id]
+
+	switch {
+	case !ok:
+		return nil, database.ErrUploadNotFound
+	case !upload.Expires.After(time.Now()):
+		return nil, database.ErrUploadExpired
+	}
+
+	copied := *upload
+
+	return &copied, nil
+}
+
+func (f *fakeUploads) Append(ctx context.Context, upload *database.Upload, lock string, content io.Reader, expires time.Time) error {
+	data, err := ioutil.ReadAll(io.LimitReader(content, upload.Length-upload.Offset))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch stored := f.uploads[upload.ID]; {
+	case f.locks[upload.ID] != lock:
+		return database.ErrUploadLocked
+	case stored.Offset != upload.Offset:
+		return database.ErrUploadConflict
+	}
+
+	f.content[upload.ID] = append(f.content[upload.ID], data...)
+	upload.Offset += int64(len(data))
+	upload.Expires = expires
+	f.uploads[upload.ID].Offset = upload.Offset
+	f.uploads[upload.ID].Expires = expires
+
+	return err
+}
+
+func (f *fakeUploads) Open(ctx context.Context, upload *database.Upload) io.Reader {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return bytes.NewReader(f.content[upload.ID])
+}
+
+func (f *fakeUploads) Finish(ctx context.Context, upload *database.Upload, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.uploads[upload.ID].Document = &key
+	delete(f.content, upload.ID)
+	upload.Document = &key
+
+	return nil
+}
+
+func (f *fakeUploads) Delete(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.uploads, id)
+	delete(f.content, id)
+
+	return nil
+}
+
+func (f *fakeUploads) DeleteExpired(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeUploads) Lock(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, ok := f.uploads[id]
+
+	switch {
+	case !ok:
+		return "", database.ErrUploadNotFound
+	case !upload.Expires.After(time.Now()):
+		return "", database.ErrUploadExpired
+	case f.locks[id] != "":
+		return "", database.ErrUploadLocked
+	}
+
+	f.next++
+	f.locks[id] = "lock" + strconv.Itoa(f.next)
+
+	return f.locks[id], nil
+}
+
+func (f *fakeUploads) Unlock(ctx context.Context, id, lock string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.locks[id] == lock {
+		delete(f.locks, id)
+	}
+
+	return nil
+}
+
+// Documents inserted from uploads, kept in memory
+type fakeDocuments struct {
+	database.DocumentsQuery
+
+	docs map[string]string
+}
+
+func (f *fakeDocuments) InsertSpool(ctx context.Context, title, author, language *string, confidence float64, spool *storage.Spool) (*database.Document, error) {
+	content, err := ioutil.ReadAll(spool)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like the database, which keeps small contents as text
+	if !database.IsText(string(content)) {
+		return nil, database.ErrNotText
+	}
+
+	key := "doc" + strconv.Itoa(len(f.docs)+1)
+	f.docs[key] = string(content)
+
+	return &database.Document{Key: key, Title: title, Length: len(content), Digest: spool.Digest}, nil
+}
+
+func (f *fakeDocuments) IncrementViews(ctx context.Context, key, ip string) {}
+
+func newUploadsServer() (*echo.Echo, *fakeUploads, *fakeDocuments) {
+	cfg := &config.Config{}
+	cfg.Nekobin.MaxTitleLength = 100
+	cfg.Nekobin.MaxAuthorLength = 100
+	cfg.Uploads.MaxSize = 1024
+	cfg.Uploads.Expiration = time.Hour
+
+	uploads := newFakeUploads()
+	docs := &fakeDocuments{docs: make(map[string]string)}
+	db := &database.Database{Documents: docs, Uploads: uploads}
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("cfg", cfg)
+			ctx.Set("db", db)
+
+			return next(ctx)
+		}
+	})
+
+	e.POST("/api/uploads", PostUpload)
+	e.HEAD("/api/uploads/:id", HeadUpload)
+	e.PATCH("/api/uploads/:id", PatchUpload)
+	e.DELETE("/api/uploads/:id", DeleteUpload)
+
+	return e, uploads, docs
+}
+
+func serve(e *echo.Echo, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func createUpload(t *testing.T, e *echo.Echo, length int) string {
+	rec := serve(e, http.MethodPost, "/api/uploads", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("notes.txt")),
+	}, "")
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating: got %d, expected %d", rec.Code, http.StatusCreated)
+	}
+
+	location := rec.Header().Get(echo.HeaderLocation)
+
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+func patchUpload(e *echo.Echo, id string, offset int, chunk string) *httptest.ResponseRecorder {
+	return serve(e, http.MethodPatch, "/api/uploads/"+id, map[string]string{
+		echo.HeaderContentType: "application/offset+octet-stream",
+		"Upload-Offset":        strconv.Itoa(offset),
+	}, chunk)
+}
+
+// An upload sent in two chunks, resumed after asking for its offset
+func TestUploadResumeAndFinish(t *testing.T) {
+	e, uploads, docs := newUploadsServer()
+	id := createUpload(t, e, 11)
+
+	if rec := patchUpload(e, id, 0, "hello "); rec.Code != http.StatusNoContent {
+		t.Fatalf("first chunk: got %d, expected %d", rec.Code, http.StatusNoContent)
+	}
+
+	rec := serve(e, http.MethodHead, "/api/uploads/"+id, nil, "")
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "6" || rec.Header().Get("Upload-Length") != "11" {
+		t.Fatalf("resuming: got %d, offset %q, length %q", rec.Code, rec.Header().Get("Upload-Offset"), rec.Header().Get("Upload-Length"))
+	}
+
+	rec = patchUpload(e, id, 6, "world")
+
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("last chunk: got %d, offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	key := rec.Header().Get("Document-Key")
+
+	if docs.docs[key] != "hello world" {
+		t.Fatalf("document %q: got %q, expected %q", key, docs.docs[key], "hello world")
+	}
+
+	if len(uploads.locks) != 0 {
+		t.Errorf("locks left: %v", uploads.locks)
+	}
+
+	// Clients which missed the response can still look the key up
+	rec = serve(e, http.MethodHead, "/api/uploads/"+id, nil, "")
+
+	if rec.Header().Get("Document-Key") != key {
+		t.Errorf("finished: got key %q, expected %q", rec.Header().Get("Document-Key"), key)
+	}
+}
+
+func TestUploadWrongOffset(t *testing.T) {
+	e, _, _ := newUploadsServer()
+	id := createUpload(t, e, 10)
+
+	if rec := patchUpload(e, id, 3, "abc"); rec.Code != http.StatusConflict {
+		t.Errorf("ahead: got %d, expected %d", rec.Code, http.StatusConflict)
+	}
+
+	patchUpload(e, id, 0, "abc")
+
+	if rec := patchUpload(e, id, 0, "abc"); rec.Code != http.StatusConflict {
+		t.Errorf("behind: got %d, expected %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestUploadLocked(t *testing.T) {
+	e, uploads, _ := newUploadsServer()
+	id := createUpload(t, e, 10)
+
+	lock, _ := uploads.Lock(context.Background(), id)
+
+	if rec := patchUpload(e, id, 0, "abc"); rec.Code != http.StatusLocked {
+		t.Errorf("patching: got %d, expected %d", rec.Code, http.StatusLocked)
+	}
+
+	if rec := serve(e, http.MethodDelete, "/api/uploads/"+id, nil, ""); rec.Code != http.StatusLocked {
+		t.Errorf("terminating: got %d, expected %d", rec.Code, http.StatusLocked)
+	}
+
+	if uploads.locks[id] != lock {
+		t.Errorf("lock released by someone else")
+	}
+}
+
+func TestUploadTermination(t *testing.T) {
+	e, _, _ := newUploadsServer()
+	id := createUpload(t, e, 10)
+	patchUpload(e, id, 0, "abc")
+
+	if rec := serve(e, http.MethodDelete, "/api/uploads/"+id, nil, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("terminating: got %d, expected %d", rec.Code, http.StatusNoContent)
+	}
+
+	if rec := serve(e, http.MethodHead, "/api/uploads/"+id, nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("terminated: got %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := patchUpload(e, id, 3, "def"); rec.Code != http.StatusNotFound {
+		t.Errorf("patching terminated: got %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUploadUnknownOrExpired(t *testing.T) {
+	e, uploads, _ := newUploadsServer()
+
+	tests := []struct {
+		method string
+		code   int
+	}{
+		{http.MethodHead, http.StatusNotFound},
+		{http.MethodPatch, http.StatusNotFound},
+		{http.MethodDelete, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := serve(e, test.method, "/api/uploads/unknown", map[string]string{
+			echo.HeaderContentType: "application/offset+octet-stream",
+			"Upload-Offset":        "0",
+		}, "abc")
+
+		if rec.Code != test.code {
+			t.Errorf("%s unknown: got %d, expected %d", test.method, rec.Code, test.code)
+		}
+	}
+
+	id := createUpload(t, e, 10)
+	uploads.uploads[id].Expires = time.Now().Add(-time.Second)
+
+	for _, method := range []string{http.MethodHead, http.MethodPatch, http.MethodDelete} {
+		rec := serve(e, method, "/api/uploads/"+id, map[string]string{
+			echo.HeaderContentType: "application/offset+octet-stream",
+			"Upload-Offset":        "0",
+		}, "abc")
+
+		if rec.Code != http.StatusGone {
+			t.Errorf("%s expired: got %d, expected %d", method, rec.Code, http.StatusGone)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var md5Name = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Reads hastebin pastes from the directory of its file store, or from a
+// pg_dump of the entries table of its Postgres store.
+func openHastebin(path string, opts *Options) (Source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &hastebinDump{file: file, rows: newCopyReader(file, "entries")}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := &hastebinFiles{dir: path}
+
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && md5Name.MatchString(entry.Name()) {
+			files.entries = append(files.entries, entry)
+		}
+	}
+
+	// Files are named after the MD5 of the keys, which can only be known
+	// back from a list of them
+	if opts.Keys != "" {
+		if files.keys, err = readHastebinKeys(opts.Keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+type hastebinFiles struct {
+	dir     string
+	entries []os.FileInfo
+	// Keys by MD5, with the extension they were shared with if any
+	keys map[string]string
+}
+
+func (h *hastebinFiles) Next() (*Document, error) {
+	if len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	entry := h.entries[0]
+	h.entries = h.entries[1:]
+
+	content, err := ioutil.ReadFile(filepath.Join(h.dir, entry.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	name := h.keys[entry.Name()]
+	ext := path.Ext(name)
+
+	doc := &Document{
+		Key:     strings.TrimSuffix(name, ext),
+		Date:    entry.ModTime(),
+		Content: string(content),
+	}
+
+	if doc.Key == "" {
+		doc.Dropped = append(doc.Dropped, "key, only its MD5 is known")
+	}
+
+	doc.setLanguage(strings.TrimPrefix(ext, "."))
+
+	return doc, nil
+}
+
+func (h *hastebinFiles) Close() error {
+	return nil
+}
+
+// Read a file of hastebin keys or URLs, one per line
+func readHastebinKeys(name string) (map[string]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+
+		if key == "" {
+			continue
+		}
+
+		// Such as https://hastebin.com/raw/abcdefghij.py
+		name := path.Base(key)
+		key = strings.TrimSuffix(name, path.Ext(name))
+
+		sum := md5.Sum([]byte(key))
+		keys[hex.EncodeToString(sum[:])] = name
+	}
+
+	return keys, scanner.Err()
+}
+
+type hastebinDump struct {
+	file *os.File
+	rows *copyReader
+}
+
+func (h *hastebinDump) Next() (*Document, error) {
+	r, err := h.rows.next()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		Key:     r.get("key"),
+		Content: r.get("value"),
+	}
+
+	if r["expiration"] != nil {
+		doc.Dropped = append(doc.Dropped, "expiration")
+	}
+
+	doc.setLanguage("")
+
+	return doc, nil
+}
+
+func (h *hastebinDump) Close() error {
+	return h.file.Close()
+}
@@ -0,0 +1,131 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package importer reads the pastes of other pastebins, so that they can be
+// moved to nekobin.
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nekobin/nekobin/languages"
+)
+
+// Document is a paste as read from another pastebin.
+type Document struct {
+	// Empty when the original one can't be known, a new one is given then
+	Key                string
+	Title              *string
+	Author             *string
+	Language           *string
+	LanguageConfidence *float64
+	// Zero when unknown
+	Date    time.Time
+	Views   int
+	Content string
+
+	// Features of the paste nekobin has no place for, such as an expiration
+	Dropped []string
+	// Why the paste can't be imported at all, if so
+	Unreadable string
+}
+
+// Source reads the pastes of a pastebin, one by one.
+type Source interface {
+	// Next returns the next paste, io.EOF past the last one
+	Next() (*Document, error)
+	Close() error
+}
+
+type Options struct {
+	// PrivateBin pastes are encrypted: a file listing their URLs, keys
+	// included, is needed to read them.
+	Keys string
+}
+
+type opener func(path string, opts *Options) (Source, error)
+
+var formats = map[string]opener{
+	"hastebin":   openHastebin,
+	"privatebin": openPrivateBin,
+	"stikked":    openStikked,
+}
+
+// Formats lists the pastebins pastes can be imported from.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+
+	for name := range formats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Open the pastes at path, the layout of which depends on the format.
+func Open(format, path string, opts *Options) (Source, error) {
+	open, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %s, expected one of: %s", format, strings.Join(Formats(), ", "))
+	}
+
+	return open(path, opts)
+}
+
+// Set the language of a document from the name a pastebin gave it, guessing
+// it from the content when nekobin doesn't know that name.
+func (doc *Document) setLanguage(name string) {
+	if name != "" {
+		if lang := languages.Lookup(name); lang != nil {
+			confidence := 1.0
+			doc.Language, doc.LanguageConfidence = &lang.Name, &confidence
+			return
+		}
+
+		doc.Dropped = append(doc.Dropped, fmt.Sprintf("language %q", name))
+	}
+
+	hint := ""
+
+	if doc.Title != nil {
+		hint = *doc.Title
+	}
+
+	if lang, confidence := languages.Detect(doc.Content, hint); lang != nil {
+		doc.Language, doc.LanguageConfidence = &lang.Name, &confidence
+	}
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
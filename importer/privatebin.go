@@ -0,0 +1,363 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var privateBinName = regexp.MustCompile(`^([0-9a-f]{16})(\.php)?$`)
+
+// Reads PrivateBin pastes from the data directory of its filesystem storage.
+// Pastes are encrypted by the browser, with a key the server never sees: only
+// those listed with their key in the keys file can be read. Comments aren't.
+func openPrivateBin(dir string, opts *Options) (Source, error) {
+	p := &privateBin{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && strings.HasSuffix(info.Name(), ".discussion") {
+			return filepath.SkipDir
+		}
+
+		if info.Mode().IsRegular() && privateBinName.MatchString(info.Name()) {
+			p.paths = append(p.paths, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Keys != "" {
+		if p.keys, err = readPrivateBinKeys(opts.Keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type privateBin struct {
+	paths []string
+	// Base58 encoded, by paste ID
+	keys map[string]string
+}
+
+// A paste as stored by PrivateBin
+type privateBinPaste struct {
+	Version int `json:"v"`
+	// Authenticated data: the cipher parameters and the paste options
+	AData []interface{} `json:"adata"`
+	CT    string        `json:"ct"`
+	Meta  struct {
+		Created    int64 `json:"created"`
+		PostDate   int64 `json:"postdate"`
+		ExpireDate int64 `json:"expire_date"`
+	} `json:"meta"`
+}
+
+// A paste once decrypted
+type privateBinContent struct {
+	Paste      string `json:"paste"`
+	Attachment string `json:"attachment"`
+}
+
+func (p *privateBin) Next() (*Document, error) {
+	if len(p.paths) == 0 {
+		return nil, io.EOF
+	}
+
+	path := p.paths[0]
+	p.paths = p.paths[1:]
+
+	id := privateBinName.FindStringSubmatch(filepath.Base(path))[1]
+	doc := &Document{Key: id}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are PHP so that they can't be served as they are
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<?php http_response_code(403); /*"))
+	data = bytes.TrimSuffix(data, []byte("*/"))
+
+	paste := &privateBinPaste{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(paste); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	doc.Date = time.Unix(paste.Meta.Created, 0)
+
+	if paste.Meta.Created == 0 {
+		doc.Date = time.Unix(paste.Meta.PostDate, 0)
+	}
+
+	if paste.Meta.ExpireDate > 0 {
+		doc.Dropped = append(doc.Dropped, "expiration")
+	}
+
+	if _, err := os.Stat(strings.TrimSuffix(path, ".php") + ".discussion"); err == nil {
+		doc.Dropped = append(doc.Dropped, "comments")
+	}
+
+	key, ok := p.keys[id]
+
+	switch {
+	case paste.Version != 2:
+		doc.Unreadable = "encrypted by PrivateBin before 1.3, unsupported"
+	case !ok:
+		doc.Unreadable = "encrypted, its key is unknown"
+	}
+
+	if doc.Unreadable != "" {
+		return doc, nil
+	}
+
+	content, err := decryptPrivateBin(paste, key)
+	if err != nil {
+		doc.Unreadable = err.Error()
+		return doc, nil
+	}
+
+	doc.Content = content.Paste
+
+	if content.Attachment != "" {
+		doc.Dropped = append(doc.Dropped, "attachment")
+	}
+
+	// Options follow the cipher parameters: formatter, discussion, burn after reading
+	formatter, _ := paste.AData[1].(string)
+
+	if len(paste.AData) > 3 && number(paste.AData[3]) == 1 {
+		doc.Dropped = append(doc.Dropped, "burn after reading")
+	}
+
+	switch formatter {
+	case "markdown":
+		doc.setLanguage("markdown")
+	case "plaintext":
+		doc.setLanguage("plaintext")
+	default:
+		doc.setLanguage("")
+	}
+
+	return doc, nil
+}
+
+func (p *privateBin) Close() error {
+	return nil
+}
+
+var errPrivateBinFormat = errors.New("unexpected PrivateBin format")
+
+// Decrypt a paste of the format introduced by PrivateBin 1.3: AES-GCM with a
+// key derived by PBKDF2-SHA256, the data authenticated being adata as JSON.
+func decryptPrivateBin(paste *privateBinPaste, encodedKey string) (*privateBinContent, error) {
+	if len(paste.AData) < 2 {
+		return nil, errPrivateBinFormat
+	}
+
+	spec, ok := paste.AData[0].([]interface{})
+	if !ok || len(spec) < 8 {
+		return nil, errPrivateBinFormat
+	}
+
+	// iv, salt, iterations, key size, tag size, algorithm, mode, compression
+	ivText, _ := spec[0].(string)
+	saltText, _ := spec[1].(string)
+	iterations := number(spec[2])
+	keySize := number(spec[3])
+	compression, _ := spec[7].(string)
+
+	if spec[5] != "aes" || spec[6] != "gcm" || iterations <= 0 || keySize <= 0 {
+		return nil, errPrivateBinFormat
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(ivText)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(saltText)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := base64.StdEncoding.DecodeString(paste.CT)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base58Decode(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Should leading zero bytes have been dropped when encoding the key
+	if len(key) < 32 {
+		key = append(make([]byte, 32-len(key)), key...)
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(key, salt, int(iterations), int(keySize/8), sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+
+	// As JSON.stringify does it
+	var adata bytes.Buffer
+	encoder := json.NewEncoder(&adata)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(paste.AData); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, iv, ct, bytes.TrimSuffix(adata.Bytes(), []byte("\n")))
+	if err != nil {
+		return nil, errors.New("couldn't be decrypted, the key is wrong or the paste has a password")
+	}
+
+	if compression == "zlib" {
+		if plaintext, err = inflate(plaintext); err != nil {
+			return nil, err
+		}
+	}
+
+	content := &privateBinContent{}
+
+	return content, json.Unmarshal(plaintext, content)
+}
+
+func number(v interface{}) int64 {
+	n, _ := v.(json.Number)
+	i, _ := n.Int64()
+
+	return i
+}
+
+// Raw deflate, or zlib should it have a header
+func inflate(data []byte) ([]byte, error) {
+	inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err == nil {
+		return inflated, nil
+	}
+
+	r, zerr := zlib.NewReader(bytes.NewReader(data))
+	if zerr != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Decode base58 as Bitcoin encodes it, leading ones standing for zero bytes
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key: %s", s)
+		}
+
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// Read a file of PrivateBin URLs, such as https://host/?f468483c313401e8#DDTpnDC...,
+// one per line.
+func readPrivateBinKeys(name string) (map[string]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		hash := strings.LastIndexByte(line, '#')
+		if hash < 0 {
+			continue
+		}
+
+		id := line[:hash]
+
+		if query := strings.LastIndexByte(id, '?'); query >= 0 {
+			id = id[query+1:]
+		}
+
+		keys[strings.TrimPrefix(id, "pasteid=")] = line[hash+1:]
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,147 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestBase58Decode(t *testing.T) {
+	// Vectors of Bitcoin Core
+	tests := []struct {
+		encoded string
+		decoded string
+	}{
+		{"", ""},
+		{"2g", "61"},
+		{"a3gV", "626262"},
+		{"aPEr", "636363"},
+		{"1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L", "00eb15231dfceb60925886b67d065299925915aeb172c06647"},
+		{"ABnLTmg", "516b6fcd0f"},
+		{"3SEo3LWLoPntC", "bf4f89001e670274dd"},
+		{"3EFU7m", "572e4794"},
+		{"EJDM8drfXA6uyA", "ecac89cad93923c02321"},
+		{"Rt5zm", "10c8511e"},
+		{"1111111111", "00000000000000000000"},
+	}
+
+	for _, test := range tests {
+		decoded, err := base58Decode(test.encoded)
+		if err != nil {
+			t.Errorf("%s: %v", test.encoded, err)
+			continue
+		}
+
+		if expected, _ := hex.DecodeString(test.decoded); !bytes.Equal(decoded, expected) {
+			t.Errorf("%s: got %x, expected %s", test.encoded, decoded, test.decoded)
+		}
+	}
+
+	if _, err := base58Decode("0OIl"); err == nil {
+		t.Error("0OIl: expected an error")
+	}
+}
+
+// The pastes in testdata were encrypted the way the PrivateBin 1.3+ client
+// does it, with WebCrypto and the same parameters, and stored the way its
+// filesystem backend does.
+func TestPrivateBin(t *testing.T) {
+	source, err := openPrivateBin("testdata/privatebin/data", &Options{Keys: "testdata/privatebin/keys.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer source.Close()
+
+	docs := make(map[string]*Document)
+
+	for {
+		doc, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs[doc.Key] = doc
+	}
+
+	tests := []struct {
+		key        string
+		content    string
+		language   string
+		dropped    []string
+		unreadable bool
+	}{
+		// Detected, too short to tell
+		{key: "f468483c313401e8", content: "print(\"héllo\")\n"},
+		// Its key starts with a zero byte
+		{key: "0a1b2c3d4e5f6a7b", content: "# Title\n\nSome *markdown*", language: "markdown", dropped: []string{"burn after reading"}},
+		{key: "9f8e7d6c5b4a3928", unreadable: true},
+	}
+
+	if len(docs) != len(tests) {
+		t.Errorf("got %d documents, expected %d", len(docs), len(tests))
+	}
+
+	for _, test := range tests {
+		doc, ok := docs[test.key]
+		if !ok {
+			t.Errorf("%s: missing", test.key)
+			continue
+		}
+
+		if test.unreadable {
+			if doc.Unreadable == "" {
+				t.Errorf("%s: expected to be unreadable, password protected", test.key)
+			}
+
+			continue
+		}
+
+		if doc.Unreadable != "" {
+			t.Errorf("%s: unreadable: %s", test.key, doc.Unreadable)
+			continue
+		}
+
+		if doc.Content != test.content {
+			t.Errorf("%s: got content %q, expected %q", test.key, doc.Content, test.content)
+		}
+
+		if test.language != "" && (doc.Language == nil || *doc.Language != test.language) {
+			t.Errorf("%s: got language %v, expected %s", test.key, doc.Language, test.language)
+		}
+
+		if !reflect.DeepEqual(doc.Dropped, test.dropped) {
+			t.Errorf("%s: got dropped %v, expected %v", test.key, doc.Dropped, test.dropped)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A row of a dumped table, by column name. NULL values are nil.
+type row map[string]*string
+
+func (r row) get(column string) string {
+	if v := r[column]; v != nil {
+		return *v
+	}
+
+	return ""
+}
+
+func (r row) int(column string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimSpace(r.get(column)), 10, 64)
+	return n
+}
+
+// Reads the rows of a table from the COPY statement of a pg_dump plain SQL
+// file, which is pg_dump's default format.
+type copyReader struct {
+	r       *bufio.Reader
+	table   string
+	columns []string
+}
+
+var copyStatement = regexp.MustCompile(`^COPY (?:"?\w+"?\.)?"?(\w+)"? \(([^)]*)\) FROM stdin;$`)
+
+func newCopyReader(r io.Reader, table string) *copyReader {
+	return &copyReader{r: bufio.NewReader(r), table: table}
+}
+
+func (c *copyReader) next() (row, error) {
+	for {
+		line, err := readLine(c.r)
+		if err == io.EOF && c.columns == nil {
+			return nil, fmt.Errorf("no COPY of table %s found, the dump must be a plain SQL one", c.table)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if c.columns == nil {
+			if match := copyStatement.FindStringSubmatch(line); match != nil && match[1] == c.table {
+				for _, column := range strings.Split(match[2], ",") {
+					c.columns = append(c.columns, strings.Trim(strings.TrimSpace(column), `"`))
+				}
+			}
+
+			continue
+		}
+
+		if line == `\.` {
+			return nil, io.EOF
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(c.columns) {
+			return nil, fmt.Errorf("table %s: %d fields for %d columns", c.table, len(fields), len(c.columns))
+		}
+
+		r := make(row, len(fields))
+
+		for i, field := range fields {
+			r[c.columns[i]] = nil
+
+			if field != `\N` {
+				value := unescapeCopy(field)
+				r[c.columns[i]] = &value
+			}
+		}
+
+		return r, nil
+	}
+}
+
+// Undo the escaping of COPY's text format
+func unescapeCopy(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+
+		switch c := s[i]; {
+		case c == 'b':
+			b.WriteByte('\b')
+		case c == 'f':
+			b.WriteByte('\f')
+		case c == 'n':
+			b.WriteByte('\n')
+		case c == 'r':
+			b.WriteByte('\r')
+		case c == 't':
+			b.WriteByte('\t')
+		case c == 'v':
+			b.WriteByte('\v')
+		case c >= '0' && c <= '7':
+			n := 0
+			j := i
+
+			for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+				n = n*8 + int(s[j]-'0')
+			}
+
+			b.WriteByte(byte(n))
+			i = j - 1
+		case c == 'x' && i+1 < len(s) && isHex(s[i+1]):
+			j := i + 1
+
+			for ; j < len(s) && j < i+3 && isHex(s[j]); j++ {
+			}
+
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// Reads the rows of a table from the INSERT statements of a mysqldump file.
+// Columns are named after the CREATE TABLE statement, unless listed by the
+// INSERT statements themselves.
+type insertReader struct {
+	r       *bufio.Reader
+	table   string
+	columns []string
+	pending []row
+}
+
+var errSyntax = errors.New("unexpected syntax")
+
+var tableColumn = regexp.MustCompile("^\\s*`(\\w+)`")
+
+func newInsertReader(r io.Reader, table string) *insertReader {
+	return &insertReader{r: bufio.NewReader(r), table: table}
+}
+
+func (ir *insertReader) next() (row, error) {
+	for len(ir.pending) == 0 {
+		line, err := readLine(ir.r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "CREATE TABLE `"+ir.table+"`"):
+			if err := ir.readColumns(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "INSERT INTO `"+ir.table+"`"):
+			// Statements may span several lines, when not dumped by mysqldump
+			for !strings.HasSuffix(line, ";") {
+				more, err := readLine(ir.r)
+				if err != nil {
+					return nil, fmt.Errorf("table %s: unterminated INSERT", ir.table)
+				}
+
+				line += "\n" + more
+			}
+
+			if err := ir.parseInsert(line[len("INSERT INTO `"+ir.table+"`"):]); err != nil {
+				return nil, fmt.Errorf("table %s: %w", ir.table, err)
+			}
+		}
+	}
+
+	r := ir.pending[0]
+	ir.pending = ir.pending[1:]
+
+	return r, nil
+}
+
+func (ir *insertReader) readColumns() error {
+	ir.columns = nil
+
+	for {
+		line, err := readLine(ir.r)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(line, ")") {
+			return nil
+		}
+
+		if match := tableColumn.FindStringSubmatch(line); match != nil {
+			ir.columns = append(ir.columns, match[1])
+		}
+	}
+}
+
+// Parse what follows INSERT INTO `table`: an optional list of columns, then
+// the values of the rows.
+func (ir *insertReader) parseInsert(s string) error {
+	p := &parser{s: s}
+	columns := ir.columns
+
+	p.space()
+
+	if p.accept('(') {
+		columns = nil
+
+		for {
+			p.space()
+
+			name, err := p.quoted('`')
+			if err != nil {
+				return err
+			}
+
+			columns = append(columns, name)
+			p.space()
+
+			if p.accept(')') {
+				break
+			}
+
+			if !p.accept(',') {
+				return errSyntax
+			}
+		}
+
+		p.space()
+	}
+
+	if columns == nil {
+		return errors.New("unknown columns, the dump must include CREATE TABLE")
+	}
+
+	if !p.keyword("VALUES") {
+		return errSyntax
+	}
+
+	for {
+		p.space()
+
+		if !p.accept('(') {
+			return errSyntax
+		}
+
+		r := make(row, len(columns))
+
+		for i := 0; ; i++ {
+			p.space()
+
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+
+			if i >= len(columns) {
+				return fmt.Errorf("more values than the %d columns", len(columns))
+			}
+
+			r[columns[i]] = value
+			p.space()
+
+			if p.accept(')') {
+				break
+			}
+
+			if !p.accept(',') {
+				return errSyntax
+			}
+		}
+
+		ir.pending = append(ir.pending, r)
+		p.space()
+
+		if p.accept(';') {
+			return nil
+		}
+
+		if !p.accept(',') {
+			return errSyntax
+		}
+	}
+}
+
+// A minimal parser of MySQL values
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) space() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *parser) accept(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) keyword(k string) bool {
+	if strings.HasPrefix(strings.ToUpper(p.s[p.i:]), k) {
+		p.i += len(k)
+		return true
+	}
+
+	return false
+}
+
+// A string, binary string, hexadecimal literal, NULL or number
+func (p *parser) value() (*string, error) {
+	if p.keyword("NULL") {
+		return nil, nil
+	}
+
+	p.keyword("_BINARY ")
+
+	if p.i < len(p.s) && p.s[p.i] == '\'' {
+		s, err := p.quoted('\'')
+		return &s, err
+	}
+
+	if p.keyword("0X") {
+		start := p.i
+
+		for p.i < len(p.s) && isHex(p.s[p.i]) {
+			p.i++
+		}
+
+		b, err := hex.DecodeString(p.s[start:p.i])
+		if err != nil {
+			return nil, err
+		}
+
+		s := string(b)
+
+		return &s, nil
+	}
+
+	start := p.i
+
+	for p.i < len(p.s) && strings.IndexByte(",) \t\r\n", p.s[p.i]) < 0 {
+		p.i++
+	}
+
+	if start == p.i {
+		return nil, errSyntax
+	}
+
+	s := p.s[start:p.i]
+
+	return &s, nil
+}
+
+// A quoted string or identifier, with MySQL escapes
+func (p *parser) quoted(quote byte) (string, error) {
+	if !p.accept(quote) {
+		return "", errSyntax
+	}
+
+	var b strings.Builder
+
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+
+		switch {
+		case c == quote:
+			// Doubled quotes stand for one
+			if !p.accept(quote) {
+				return b.String(), nil
+			}
+
+			b.WriteByte(quote)
+		case c == '\\' && quote == '\'' && p.i < len(p.s):
+			e := p.s[p.i]
+			p.i++
+
+			switch e {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(26)
+			case '%', '_':
+				// Only escapes in LIKE patterns
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", errors.New("unterminated string")
+}
+
+// Read a line, without its line break. Lines of dumps can be very long.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,151 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Read every row of a table, with NULL values as "<NULL>"
+func readRows(t *testing.T, next func() (row, error)) []map[string]string {
+	var rows []map[string]string
+
+	for {
+		r, err := next()
+		if err == io.EOF {
+			return rows
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := make(map[string]string, len(r))
+
+		for column, value := range r {
+			if value == nil {
+				values[column] = "<NULL>"
+			} else {
+				values[column] = *value
+			}
+		}
+
+		rows = append(rows, values)
+	}
+}
+
+const pgDump = `--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+
+COPY public.other (id, value) FROM stdin;
+1	ignored
+\.
+
+COPY public.entries (id, key, value, expiration) FROM stdin;
+1	abc	line one\nline two\ttabbed	\N
+2	def	back\\slash and \101\x42 and \u	1600000000
+\.
+`
+
+func TestCopyReader(t *testing.T) {
+	rows := readRows(t, newCopyReader(strings.NewReader(pgDump), "entries").next)
+
+	expected := []map[string]string{
+		{"id": "1", "key": "abc", "value": "line one\nline two\ttabbed", "expiration": "<NULL>"},
+		{"id": "2", "key": "def", "value": `back\slash and AB and u`, "expiration": "1600000000"},
+	}
+
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("got %q, expected %q", rows, expected)
+	}
+}
+
+func TestCopyReaderMissingTable(t *testing.T) {
+	_, err := newCopyReader(strings.NewReader(pgDump), "missing").next()
+	if err == nil || err == io.EOF {
+		t.Errorf("got %v, expected an error", err)
+	}
+}
+
+const mysqlDump = "-- MySQL dump 10.13\n" +
+	"\n" +
+	"DROP TABLE IF EXISTS `pastes`;\n" +
+	"CREATE TABLE `pastes` (\n" +
+	"  `id` int(10) NOT NULL AUTO_INCREMENT,\n" +
+	"  `pid` varchar(8) NOT NULL,\n" +
+	"  `title` varchar(32) DEFAULT NULL,\n" +
+	"  `raw` longtext,\n" +
+	"  PRIMARY KEY (`id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8;\n" +
+	"\n" +
+	"LOCK TABLES `pastes` WRITE;\n" +
+	"INSERT INTO `pastes` VALUES (1,'a1','It''s','one\\ntwo\\\\three \\'quoted\\' 100\\%'),(2,'b2',NULL,_binary 'bin\\0ary')," +
+	"(3,'c3','hex',0x686578);\n" +
+	"INSERT INTO `pastes` (`pid`, `raw`) VALUES\n" +
+	"  ('d4', 'semi;colon, (parens)'),\n" +
+	"  ('e5', '');\n" +
+	"INSERT INTO `other` VALUES (1,'ignored');\n" +
+	"UNLOCK TABLES;\n"
+
+func TestInsertReader(t *testing.T) {
+	rows := readRows(t, newInsertReader(strings.NewReader(mysqlDump), "pastes").next)
+
+	expected := []map[string]string{
+		{"id": "1", "pid": "a1", "title": "It's", "raw": "one\ntwo\\three 'quoted' 100\\%"},
+		{"id": "2", "pid": "b2", "title": "<NULL>", "raw": "bin\x00ary"},
+		{"id": "3", "pid": "c3", "title": "hex", "raw": "hex"},
+		{"pid": "d4", "raw": "semi;colon, (parens)"},
+		{"pid": "e5", "raw": ""},
+	}
+
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("got %q, expected %q", rows, expected)
+	}
+}
+
+func TestInsertReaderErrors(t *testing.T) {
+	dumps := map[string]string{
+		"without CREATE TABLE":  "INSERT INTO `pastes` VALUES (1,'a');\n",
+		"unterminated string":   "INSERT INTO `pastes` (`id`) VALUES ('a);\n",
+		"too many values":       "INSERT INTO `pastes` (`id`) VALUES (1,2);\n",
+		"unterminated INSERT":   "INSERT INTO `pastes` (`id`) VALUES (1)\n",
+		"missing parenthesis":   "INSERT INTO `pastes` (`id`) VALUES 1;\n",
+		"garbage between rows":  "INSERT INTO `pastes` (`id`) VALUES (1) (2);\n",
+		"missing VALUES":        "INSERT INTO `pastes` (`id`) (1);\n",
+		"unquoted column names": "INSERT INTO `pastes` (id) VALUES (1);\n",
+	}
+
+	for name, dump := range dumps {
+		if _, err := newInsertReader(strings.NewReader(dump), "pastes").next(); err == nil || err == io.EOF {
+			t.Errorf("%s: got %v, expected an error", name, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package importer
+
+import (
+	"os"
+	"time"
+)
+
+// Reads stikked pastes from a mysqldump of its database.
+func openStikked(path string, _ *Options) (Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stikked{file: file, rows: newInsertReader(file, "pastes")}, nil
+}
+
+type stikked struct {
+	file *os.File
+	rows *insertReader
+}
+
+func (s *stikked) Next() (*Document, error) {
+	r, err := s.rows.next()
+	if err != nil {
+		return nil, err
+	}
+
+	// The paste column holds the highlighted HTML, raw the content itself
+	doc := &Document{
+		Key:     r.get("pid"),
+		Title:   optional(r.get("title")),
+		Author:  optional(r.get("name")),
+		Views:   int(r.int("hits")),
+		Content: r.get("raw"),
+	}
+
+	if created := r.int("created"); created > 0 {
+		doc.Date = time.Unix(created, 0)
+	}
+
+	// Private pastes are only unlisted, like any nekobin document. Passwords
+	// can't be carried over though, and dropping them would expose the paste.
+	if password := r.get("password"); password != "" && password != "EMPTY PASSWORD" {
+		doc.Unreadable = "password protected"
+	}
+
+	if r.int("expire") > 0 {
+		doc.Dropped = append(doc.Dropped, "expiration")
+	}
+
+	if replyTo := r.get("replyto"); replyTo != "" && replyTo != "0" {
+		doc.Dropped = append(doc.Dropped, "reply to "+replyTo)
+	}
+
+	doc.setLanguage(r.get("lang"))
+
+	return doc, nil
+}
+
+func (s *stikked) Close() error {
+	return s.file.Close()
+}
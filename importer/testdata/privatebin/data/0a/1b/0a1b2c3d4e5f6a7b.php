@@ -0,0 +1 @@
+<?php http_response_code(403); /*{"v":2,"adata":[["ITJ7o/b3CEY3NN6RZyKizA==","qDpjDhrWJ8M=",100000,256,128,"aes","gcm","zlib"],"markdown",0,1],"ct":"EWcWbyriOL+i4O2l8dz23V1jOBlbYB2DcbNucnrj6SjvMFcobGG3oA8NOln2Q3Mm2PqGBuj32q4=","meta":{"expire_date":0,"created":1600000001}}*/
\ No newline at end of file
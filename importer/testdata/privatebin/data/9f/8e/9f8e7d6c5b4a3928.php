@@ -0,0 +1 @@
+<?php http_response_code(403); /*{"v":2,"adata":[["eRzsgKTKT1RlWfdJrcDzZA==","1iyNerQ6qxA=",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0],"ct":"5FG3KqVcC683rhmHp0foCekrG9vMrBg8LtZhxKeES4XRXaFM","meta":{"expire_date":0,"created":1600000000}}*/
\ No newline at end of file
@@ -0,0 +1 @@
+<?php http_response_code(403); /*{"v":2,"adata":[["NOTFWqMQsHUWJBaPs4dD7A==","EZfRuUeO0vI=",100000,256,128,"aes","gcm","zlib"],"syntaxhighlighting",0,0],"ct":"xsyu7JArt5voEfigl+Ro6UVeqi1B/3aOll0gFKCIYOfxQW4tpTvBLkzHyDRFJUbusA==","meta":{"expire_date":0,"created":1600000000}}*/
\ No newline at end of file
@@ -0,0 +1,407 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package languages
+
+import (
+	"encoding/json"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Only the beginning of a document is looked at when guessing.
+	detectMaxBytes = 32 * 1024
+
+	// Heuristic scores below this are not trusted at all.
+	minTokenScore = 4.0
+	// Nor are guesses whose share of the total score is below this.
+	minConfidence = 0.35
+)
+
+var (
+	// Words may be hyphenated, as CSS properties are. Colons after them are
+	// left to the operators, see tokenize.
+	tokenRegexp = regexp.MustCompile(`<\?php|#include|#!|:=|=>|->|::|===|~=|=~|\$[A-Za-z_]\w*|@?[A-Za-z_]\w*(?:-[A-Za-z_]\w*)*`)
+
+	vimModeline   = regexp.MustCompile(`(?:vi|vim|ex):.*?\b(?:ft|filetype|syntax)=([\w+#-]+)`)
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?mode:\s*)?([\w+#-]+)\s*(?:;.*?)?-\*-`)
+)
+
+// Interpreters found in shebang lines, without version suffixes.
+var interpreters = map[string]string{
+	"sh":      "shell",
+	"bash":    "shell",
+	"zsh":     "shell",
+	"ksh":     "shell",
+	"dash":    "shell",
+	"ash":     "shell",
+	"python":  "python",
+	"node":    "javascript",
+	"nodejs":  "javascript",
+	"deno":    "typescript",
+	"ts-node": "typescript",
+	"ruby":    "ruby",
+	"perl":    "perl",
+	"php":     "php",
+	"lua":     "lua",
+	"runghc":  "haskell",
+	"scala":   "scala",
+}
+
+// Emacs and vim mode names that differ from our own.
+var modeAliases = map[string]string{
+	"c++":    "cpp",
+	"js":     "javascript",
+	"js2":    "javascript",
+	"sh":     "shell",
+	"bash":   "shell",
+	"zsh":    "shell",
+	"cs":     "csharp",
+	"conf":   "ini",
+	"dosini": "ini",
+	"text":   "plaintext",
+	"rs":     "rust",
+}
+
+// Distinctive tokens of every language along with their weight.
+var tokenWeights = map[string]map[string]float64{
+	"go": {
+		"package": 2, "func": 3, ":=": 3, "import": 1, "defer": 3, "chan": 3, "go": 1,
+		"struct": 1, "interface": 1, "fmt": 2, "nil": 2, "err": 1, "range": 2,
+	},
+	"python": {
+		"def": 3, "import": 1, "self": 2, "elif": 3, "None": 2, "True": 1, "False": 1, "lambda": 2,
+		"__init__": 3, "print": 1, "from": 1, "pass": 2, "except": 2, "yield": 1, "__name__": 3,
+	},
+	"javascript": {
+		"function": 2, "const": 2, "let": 1, "var": 1, "=>": 2, "console": 3, "require": 2,
+		"undefined": 3, "this": 1, "async": 1, "await": 1, "===": 3, "document": 2, "window": 2,
+	},
+	"typescript": {
+		"interface": 2, "type": 1, "implements": 1, "readonly": 3, "number": 2, "string": 1,
+		"export": 1, "enum": 1, "const": 1, "=>": 1, "boolean": 2, "any": 1,
+	},
+	"c": {
+		"#include": 3, "int": 1, "void": 1, "char": 2, "printf": 3, "malloc": 3, "free": 1,
+		"struct": 1, "sizeof": 2, "NULL": 2, "->": 1, "unsigned": 2, "typedef": 2,
+	},
+	"cpp": {
+		"#include": 2, "std::": 4, "::": 2, "namespace": 2, "template": 3, "class": 1, "cout": 3,
+		"public:": 3, "private:": 3, "virtual": 2, "nullptr": 3, "auto": 1, "const": 1,
+	},
+	"csharp": {
+		"using": 2, "namespace": 2, "public": 1, "static": 1, "void": 1, "class": 1, "var": 1,
+		"string": 1, "Console": 3, "get": 1, "set": 1, "async": 1, "Task": 2,
+	},
+	"java": {
+		"public": 2, "class": 1, "static": 1, "void": 1, "import": 1, "private": 1, "System": 2,
+		"new": 1, "extends": 1, "final": 2, "String": 2, "@Override": 4, "throws": 3,
+	},
+	"kotlin": {
+		"fun": 4, "val": 3, "var": 1, "override": 1, "package": 1, "import": 1, "data": 1,
+		"object": 1, "companion": 4, "when": 1,
+	},
+	"rust": {
+		"fn": 4, "let": 1, "mut": 4, "impl": 3, "pub": 2, "use": 1, "match": 1, "crate": 3,
+		"::": 1, "Some": 1, "None": 1, "Ok": 1, "Err": 1, "unwrap": 3,
+	},
+	"ruby": {
+		"def": 2, "end": 2, "require": 1, "puts": 3, "attr_accessor": 4, "module": 1, "class": 1,
+		"do": 1, "elsif": 4, "nil": 1, "unless": 2,
+	},
+	"php": {
+		"<?php": 10, "$this": 3, "function": 1, "echo": 1, "->": 1, "public": 1, "array": 2,
+		"namespace": 1, "use": 1,
+	},
+	"shell": {
+		"echo": 2, "fi": 4, "then": 2, "if": 1, "done": 3, "do": 1, "esac": 4, "export": 2,
+		"sudo": 3, "cd": 1, "grep": 2, "$HOME": 3, "$PATH": 3, "local": 1,
+	},
+	"sql": {
+		"SELECT": 3, "FROM": 2, "WHERE": 2, "INSERT": 3, "INTO": 2, "CREATE": 2, "TABLE": 3,
+		"UPDATE": 2, "JOIN": 3, "VALUES": 3, "ORDER": 1, "BY": 1, "GROUP": 1,
+	},
+	"css": {
+		"color:": 2, "margin:": 3, "padding:": 3, "display:": 3, "font-size:": 3,
+		"background:": 3, "border:": 2, "px": 1, "width:": 1, "height:": 1, "margin-top:": 3,
+		"margin-bottom:": 3, "font-family:": 4, "font-weight:": 4, "text-align:": 4, "line-height:": 4,
+		"border-radius:": 4, "background-color:": 4, "z-index:": 4, "align-items:": 4,
+		"justify-content:": 4, "em": 1, "rem": 2,
+	},
+	"lua": {
+		"local": 3, "function": 1, "end": 1, "then": 1, "nil": 1, "elseif": 3, "require": 1, "~=": 3,
+	},
+	"perl": {
+		"my": 3, "use": 1, "strict": 3, "warnings": 2, "sub": 3, "print": 1, "$_": 3, "=~": 3,
+	},
+	"swift": {
+		"func": 2, "let": 1, "var": 1, "import": 1, "guard": 3, "struct": 1, "extension": 2,
+		"protocol": 2, "UIKit": 4, "Foundation": 3,
+	},
+	"scala": {
+		"def": 1, "val": 2, "object": 2, "case": 1, "trait": 3, "extends": 1, "implicit": 4,
+		"import": 1,
+	},
+	"haskell": {
+		"module": 1, "where": 2, "import": 1, "data": 1, "::": 2, "->": 1, "let": 1, "in": 1,
+		"instance": 2, "deriving": 4, "Maybe": 3,
+	},
+	"nginx": {
+		"server": 2, "location": 3, "listen": 2, "proxy_pass": 4, "server_name": 4, "root": 1,
+	},
+}
+
+// Patterns matched against each line, along with their weight.
+var linePatterns = map[string][]struct {
+	regexp *regexp.Regexp
+	weight float64
+}{
+	"diff": {
+		{regexp.MustCompile(`^(---|\+\+\+) \S`), 3},
+		{regexp.MustCompile(`^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`), 5},
+		{regexp.MustCompile(`^diff --git `), 5},
+	},
+	"markdown": {
+		{regexp.MustCompile("^```"), 3},
+		{regexp.MustCompile(`^#{1,6} \S`), 2},
+		{regexp.MustCompile(`^\s*[-*] \[[ x]\] `), 3},
+		{regexp.MustCompile(`\[[^]]+\]\([^)]+\)`), 2},
+	},
+	"html": {
+		{regexp.MustCompile(`(?i)^\s*<!doctype html`), 10},
+		{regexp.MustCompile(`(?i)<(html|head|body|div|span|script|p)[\s>]`), 2},
+	},
+	"xml": {
+		{regexp.MustCompile(`^\s*<\?xml `), 10},
+	},
+	"dockerfile": {
+		{regexp.MustCompile(`^FROM \S+`), 4},
+		{regexp.MustCompile(`^(RUN|CMD|COPY|ADD|ENTRYPOINT|WORKDIR|EXPOSE|ENV) `), 2},
+	},
+	"yaml": {
+		{regexp.MustCompile(`^---\s*$`), 2},
+		{regexp.MustCompile(`^\s*[\w.-]+:(\s+[^{;]+)?$`), 2.5},
+		{regexp.MustCompile(`^\s*- [\w.-]+:`), 2},
+	},
+	"ini": {
+		{regexp.MustCompile(`^\[[\w .-]+\]\s*$`), 2},
+		{regexp.MustCompile(`^[\w.-]+\s*=\s*[^"']*$`), 1},
+	},
+	"toml": {
+		{regexp.MustCompile(`^\[\[?[\w.-]+\]\]?\s*$`), 2},
+		{regexp.MustCompile(`^[\w.-]+\s*=\s*("|\[|\d|true|false)`), 2},
+	},
+}
+
+// Detect guesses the language of a document from its content. The optional
+// filename is used as a hint. The returned confidence ranges from 0 to 1,
+// nil is returned when nothing could be guessed reliably.
+func Detect(content, filename string) (lang *Language, confidence float64) {
+	if filename != "" {
+		if lang := ByFilename(filename); lang != nil {
+			return lang, 0.9
+		}
+	}
+
+	if len(content) > detectMaxBytes {
+		content = content[:detectMaxBytes]
+	}
+
+	lines := strings.Split(content, "\n")
+
+	if lang := detectModeline(lines); lang != nil {
+		return lang, 0.95
+	}
+
+	if lang := detectShebang(lines[0]); lang != nil {
+		return lang, 0.9
+	}
+
+	if trimmed := strings.TrimSpace(content); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return byName["json"], 0.99
+		}
+	}
+
+	return detectHeuristic(content, lines)
+}
+
+func detectModeline(lines []string) *Language {
+	candidates := lines
+
+	// Modelines live in the first or last few lines only.
+	if len(lines) > 10 {
+		candidates = append(lines[:5:5], lines[len(lines)-5:]...)
+	}
+
+	for _, line := range candidates {
+		for _, re := range []*regexp.Regexp{vimModeline, emacsModeline} {
+			if match := re.FindStringSubmatch(line); match != nil {
+				if lang := lookupMode(match[1]); lang != nil {
+					return lang
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func detectShebang(line string) *Language {
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+
+	fields := strings.Fields(line[2:])
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	interpreter := path.Base(fields[0])
+
+	// #!/usr/bin/env [-S] interpreter
+	if interpreter == "env" {
+		interpreter = ""
+
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				interpreter = field
+				break
+			}
+		}
+	}
+
+	// python3.8 -> python
+	interpreter = strings.TrimRight(interpreter, "0123456789.")
+
+	if name, ok := interpreters[interpreter]; ok {
+		return byName[name]
+	}
+
+	return nil
+}
+
+func detectHeuristic(content string, lines []string) (*Language, float64) {
+	scores := make(map[string]float64)
+
+	counts := tokenize(content)
+
+	for name, weights := range tokenWeights {
+		for token, weight := range weights {
+			if count := counts[token]; count > 0 {
+				// Logarithmic so that a single repeated token can't dominate.
+				scores[name] += weight * math.Log1p(float64(count))
+			}
+		}
+	}
+
+	for name, patterns := range linePatterns {
+		for _, pattern := range patterns {
+			matches := 0
+
+			for _, line := range lines {
+				if pattern.regexp.MatchString(line) {
+					matches++
+				}
+			}
+
+			if matches > 0 {
+				scores[name] += pattern.weight * math.Log1p(float64(matches))
+			}
+		}
+	}
+
+	var best string
+	var bestScore, total float64
+
+	for name, score := range scores {
+		total += score
+
+		if score > bestScore || score == bestScore && name < best {
+			best, bestScore = name, score
+		}
+	}
+
+	if bestScore < minTokenScore {
+		return nil, 0
+	}
+
+	confidence := bestScore / total
+
+	if confidence < minConfidence {
+		return nil, 0
+	}
+
+	return byName[best], confidence
+}
+
+// Count the tokens of content. Words followed by a colon or two are counted
+// both alone and with them ("std::", "public:", "margin:"), and hyphenated
+// words both whole and in parts, since they may as well be subtractions.
+func tokenize(content string) map[string]int {
+	counts := make(map[string]int)
+
+	for _, loc := range tokenRegexp.FindAllStringIndex(content, -1) {
+		token, rest := content[loc[0]:loc[1]], content[loc[1]:]
+		counts[token]++
+
+		if !isWord(token) {
+			continue
+		}
+
+		if strings.Contains(token, "-") {
+			for _, part := range strings.Split(token, "-") {
+				counts[part]++
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "::"):
+			counts[token+"::"]++
+		case strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, ":="):
+			counts[token+":"]++
+		}
+	}
+
+	return counts
+}
+
+func isWord(token string) bool {
+	c := strings.TrimPrefix(token, "@")[0]
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func lookupMode(mode string) *Language {
+	mode = strings.ToLower(mode)
+
+	if alias, ok := modeAliases[mode]; ok {
+		mode = alias
+	}
+
+	return Lookup(mode)
+}
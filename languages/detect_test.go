@@ -0,0 +1,174 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package languages
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		filename string
+		expected string
+	}{
+		{"filename", "anything", "main.rs", "rust"},
+		{"vim modeline", "x = 1\n# vim: set ft=python:\n", "", "python"},
+		{"emacs modeline", "// -*- mode: c++ -*-\nint x;\n", "", "cpp"},
+		{"shebang", "#!/usr/bin/env python3\nprint(1)\n", "", "python"},
+		{"shebang with options", "#!/usr/bin/env -S bash -e\nls\n", "", "shell"},
+		{"json", `{"a": [1, 2, {"b": null}]}`, "", "json"},
+		{"go", `package main
+
+import "fmt"
+
+func main() {
+	ch := make(chan int)
+	go func() { defer close(ch); ch <- 1 }()
+	for v := range ch {
+		fmt.Println(v, nil)
+	}
+}
+`, "", "go"},
+		{"python", `import os
+
+class Greeter:
+    def __init__(self, name=None):
+        self.name = name
+
+    def greet(self):
+        if self.name is None:
+            pass
+        elif self.name:
+            print("hello", self.name)
+
+if __name__ == "__main__":
+    Greeter("world").greet()
+`, "", "python"},
+		{"cpp", `#include <iostream>
+#include <vector>
+
+namespace demo {
+template <typename T>
+class Box {
+public:
+    explicit Box(T value) : value_(value) {}
+    virtual ~Box() = default;
+private:
+    T value_;
+};
+}
+
+int main() {
+    std::vector<int> values{1, 2, 3};
+    for (auto v : values) std::cout << v << std::endl;
+    return 0;
+}
+`, "", "cpp"},
+		{"css", `body {
+  margin: 0;
+  padding: 0;
+  font-size: 14px;
+}
+
+.header {
+  display: flex;
+  background: #333;
+  color: white;
+  border: 1px solid black;
+}
+`, "", "css"},
+		{"cpp with std:: only", "int main() {\n  std::string name;\n  std::getline(std::cin, name);\n  std::cout << name;\n}\n", "", "cpp"},
+		{"css with hyphenated properties", ".title {\n  font-size: 2em;\n  margin-top: 0;\n  text-align: center;\n}\n", "", "css"},
+		{"rust", `use std::collections::HashMap;
+
+fn main() {
+    let mut counts: HashMap<&str, i32> = HashMap::new();
+    for word in "a b a".split(' ') {
+        *counts.entry(word).or_insert(0) += 1;
+    }
+    let first = counts.get("a").unwrap();
+    println!("{}", first);
+}
+`, "", "rust"},
+		{"sql", `SELECT d.key, COUNT(*)
+FROM documents d
+JOIN views v ON v.key = d.key
+WHERE d.date > 0
+GROUP BY d.key
+ORDER BY 2 DESC;
+`, "", "sql"},
+		{"diff", `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+-old
++new
+`, "", "diff"},
+		{"prose", "Just a few words, nothing to see here.", "", ""},
+	}
+
+	for _, test := range tests {
+		lang, confidence := Detect(test.content, test.filename)
+		name := ""
+
+		if lang != nil {
+			name = lang.Name
+		}
+
+		if name != test.expected {
+			t.Errorf("%s: got %q (%.2f), expected %q", test.name, name, confidence, test.expected)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"std::cout", []string{"std", "std::", "::", "cout"}},
+		{"public:", []string{"public", "public:"}},
+		{"font-size: 1em", []string{"font-size", "font-size:", "font", "size", "em"}},
+		{"x := 1", []string{"x", ":="}},
+		{"x:=1", []string{"x", ":="}},
+		{"p->next", []string{"p", "->", "next"}},
+		{"echo $HOME", []string{"echo", "$HOME"}},
+		{"@Override", []string{"@Override"}},
+	}
+
+	for _, test := range tests {
+		counts := tokenize(test.content)
+
+		for _, token := range test.expected {
+			if counts[token] == 0 {
+				t.Errorf("%q: %q not found in %v", test.content, token, counts)
+			}
+		}
+
+		if len(counts) != len(test.expected) {
+			t.Errorf("%q: got %v, expected %v", test.content, counts, test.expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package languages
+
+import (
+	"path"
+	"strings"
+)
+
+type Language struct {
+	// Name is the canonical identifier stored with documents and understood
+	// by the web frontend editor (CodeMirror) as a mode name or alias.
+	Name       string
+	Title      string
+	Extensions []string
+	MimeType   string
+}
+
+var registry = []*Language{
+	{"plaintext", "Plain Text", []string{"txt", "text", "log"}, "text/plain"},
+	{"c", "C", []string{"c", "h"}, "text/x-csrc"},
+	{"cpp", "C++", []string{"cpp", "cc", "cxx", "hpp", "hh", "hxx"}, "text/x-c++src"},
+	{"csharp", "C#", []string{"cs"}, "text/x-csharp"},
+	{"css", "CSS", []string{"css"}, "text/css"},
+	{"diff", "Diff", []string{"diff", "patch"}, "text/x-diff"},
+	{"dockerfile", "Dockerfile", []string{"dockerfile"}, "text/x-dockerfile"},
+	{"go", "Go", []string{"go"}, "text/x-go"},
+	{"haskell", "Haskell", []string{"hs"}, "text/x-haskell"},
+	{"html", "HTML", []string{"html", "htm"}, "text/html"},
+	{"ini", "INI", []string{"ini", "cfg", "conf", "properties"}, "text/x-properties"},
+	{"java", "Java", []string{"java"}, "text/x-java"},
+	{"javascript", "JavaScript", []string{"js", "mjs", "cjs"}, "text/javascript"},
+	{"json", "JSON", []string{"json"}, "application/json"},
+	{"kotlin", "Kotlin", []string{"kt", "kts"}, "text/x-kotlin"},
+	{"lua", "Lua", []string{"lua"}, "text/x-lua"},
+	{"markdown", "Markdown", []string{"md", "markdown"}, "text/markdown"},
+	{"nginx", "Nginx", []string{"nginx"}, "text/x-nginx-conf"},
+	{"perl", "Perl", []string{"pl", "pm"}, "text/x-perl"},
+	{"php", "PHP", []string{"php"}, "application/x-httpd-php"},
+	{"python", "Python", []string{"py", "pyw"}, "text/x-python"},
+	{"ruby", "Ruby", []string{"rb"}, "text/x-ruby"},
+	{"rust", "Rust", []string{"rs"}, "text/x-rustsrc"},
+	{"scala", "Scala", []string{"scala"}, "text/x-scala"},
+	{"shell", "Shell", []string{"sh", "bash", "zsh"}, "text/x-sh"},
+	{"sql", "SQL", []string{"sql"}, "text/x-sql"},
+	{"swift", "Swift", []string{"swift"}, "text/x-swift"},
+	{"toml", "TOML", []string{"toml"}, "text/x-toml"},
+	{"typescript", "TypeScript", []string{"ts", "tsx"}, "application/typescript"},
+	{"xml", "XML", []string{"xml", "svg"}, "application/xml"},
+	{"yaml", "YAML", []string{"yaml", "yml"}, "text/x-yaml"},
+}
+
+var (
+	byName      = make(map[string]*Language)
+	byExtension = make(map[string]*Language)
+)
+
+func init() {
+	for _, lang := range registry {
+		byName[lang.Name] = lang
+
+		for _, ext := range lang.Extensions {
+			byExtension[ext] = lang
+		}
+	}
+}
+
+// All returns every known language, in registry order.
+func All() []*Language {
+	return registry
+}
+
+// Get returns the language with the given canonical name, or nil.
+func Get(name string) *Language {
+	return byName[strings.ToLower(name)]
+}
+
+// ByExtension returns the language a file extension (with or without the
+// leading dot) belongs to, or nil.
+func ByExtension(ext string) *Language {
+	return byExtension[strings.ToLower(strings.TrimPrefix(ext, "."))]
+}
+
+// ByFilename returns the language a file name belongs to, or nil.
+func ByFilename(filename string) *Language {
+	base := strings.ToLower(path.Base(filename))
+
+	if base == "dockerfile" {
+		return byName["dockerfile"]
+	}
+
+	return ByExtension(path.Ext(base))
+}
+
+// Lookup accepts either a canonical name or a file extension.
+func Lookup(s string) *Language {
+	if lang := Get(s); lang != nil {
+		return lang
+	}
+
+	return ByExtension(s)
+}
+
+func (lang *Language) ContentType() string {
+	return lang.MimeType + "; charset=UTF-8"
+}
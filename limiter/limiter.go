@@ -25,6 +25,8 @@
 package limiter
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"sync"
 	"time"
@@ -32,6 +34,8 @@ import (
 	"golang.org/x/time/rate"
 )
 
+var ErrBurst = errors.New("more events than the burst")
+
 type Limit struct {
 	Amount int
 	Period time.Duration
@@ -67,9 +71,11 @@ func (lim *Limiter) add(key string) {
 	}
 }
 
-func (lim *Limiter) check(key string) bool {
+func (lim *Limiter) check(key string, n int) bool {
+	now := time.Now()
+
 	for _, keyLim := range lim.limiters[key] {
-		if !keyLim.Allow() {
+		if !keyLim.AllowN(now, n) {
 			return false
 		}
 	}
@@ -78,6 +84,12 @@ func (lim *Limiter) check(key string) bool {
 }
 
 func (lim *Limiter) IsAllowed(key string) bool {
+	return lim.IsAllowedN(key, 1)
+}
+
+// IsAllowedN reports whether n events, such as bytes, may happen now.
+// It's never the case for more than Burst events.
+func (lim *Limiter) IsAllowedN(key string, n int) bool {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
@@ -87,5 +99,76 @@ func (lim *Limiter) IsAllowed(key string) bool {
 		lim.add(key)
 	}
 
-	return lim.check(key)
+	return lim.check(key, n)
+}
+
+// WaitN blocks until n events, such as bytes, may happen, or until ctx is
+// done, returning how long it waited. It fails right away for more than
+// Burst events.
+func (lim *Limiter) WaitN(ctx context.Context, key string, n int) (time.Duration, error) {
+	lim.mu.Lock()
+
+	_, exists := lim.limiters[key]
+
+	if !exists {
+		lim.add(key)
+	}
+
+	now := time.Now()
+	delay := time.Duration(0)
+	reservations := make([]*rate.Reservation, 0, len(lim.limiters[key]))
+
+	for _, keyLim := range lim.limiters[key] {
+		reservation := keyLim.ReserveN(now, n)
+
+		if !reservation.OK() {
+			cancel(reservations, now)
+			lim.mu.Unlock()
+
+			return 0, ErrBurst
+		}
+
+		if d := reservation.DelayFrom(now); d > delay {
+			delay = d
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	lim.mu.Unlock()
+
+	if delay == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		// Give back the events which did not happen
+		cancel(reservations, time.Now())
+		return 0, ctx.Err()
+	}
+}
+
+func cancel(reservations []*rate.Reservation, now time.Time) {
+	for _, reservation := range reservations {
+		reservation.CancelAt(now)
+	}
+}
+
+// Burst is the largest amount of events allowed at once, 0 if unlimited.
+func (lim *Limiter) Burst() int {
+	burst := 0
+
+	for _, limit := range lim.limits {
+		if burst == 0 || limit.Amount < burst {
+			burst = limit.Amount
+		}
+	}
+
+	return burst
 }
@@ -0,0 +1,78 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package limiter
+
+import (
+	"context"
+	"io"
+)
+
+// Reader limits the rate at which bytes are read from a stream, counting each
+// byte as an event for key. Reads are slowed down to the limit rather than
+// failing, until ctx is done.
+type Reader struct {
+	r         io.Reader
+	ctx       context.Context
+	lim       *Limiter
+	key       string
+	throttled bool
+}
+
+func NewReader(ctx context.Context, lim *Limiter, key string, r io.Reader) *Reader {
+	return &Reader{
+		r:   r,
+		ctx: ctx,
+		lim: lim,
+		key: key,
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if burst := r.lim.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+
+	if n > 0 {
+		// The bytes are read already: hand them over once allowed
+		delay, waitErr := r.lim.WaitN(r.ctx, r.key, n)
+
+		if waitErr != nil {
+			return 0, waitErr
+		}
+
+		if delay > 0 {
+			r.throttled = true
+		}
+	}
+
+	return n, err
+}
+
+// Throttled reports whether reads had to be slowed down.
+func (r *Reader) Throttled() bool {
+	return r.throttled
+}
@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/compress"
+)
+
+// Responses known to be shorter than this are not worth compressing
+const compressMinLength = 1024
+
+// Middleware to compress responses with the best encoding the client accepts
+func Compress() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			res := ctx.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+			// Handlers only know about identity entity tags: the encoding is
+			// added to them on the way out and removed on the way in, here only.
+			match := ctx.Request().Header.Get("If-None-Match")
+
+			if match != "" {
+				ctx.Request().Header.Set("If-None-Match", compress.StripETags(match))
+			}
+
+			encoding := compress.Negotiate(
+				ctx.Request().Header.Get(echo.HeaderAcceptEncoding),
+				compress.Encodings...,
+			)
+
+			if encoding == "" {
+				return next(ctx)
+			}
+
+			writer := &compressWriter{ResponseWriter: res.Writer, encoding: encoding, ifNoneMatch: match}
+			res.Writer = writer
+
+			defer func() {
+				res.Writer = writer.ResponseWriter
+
+				if err := writer.Close(); err != nil {
+					ctx.Logger().Error(err)
+				}
+			}()
+
+			return next(ctx)
+		}
+	}
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+
+	encoding string
+	writer   io.WriteCloser
+	decided  bool
+	// As sent by the client, encodings included
+	ifNoneMatch string
+}
+
+// Decide whether to compress, right before the headers are sent
+func (w *compressWriter) decide(code int) {
+	if w.decided {
+		return
+	}
+
+	w.decided = true
+	header := w.Header()
+
+	// Already encoded, most likely stored precompressed
+	if encoding := header.Get(echo.HeaderContentEncoding); encoding != "" {
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", compress.ETag(etag, encoding))
+		}
+
+		return
+	}
+
+	switch code {
+	case http.StatusNotModified:
+		// Still refers to the variant the client has, which is the encoded
+		// one unless it was too short to be compressed
+		if etag := header.Get("ETag"); etag != "" && !hasETag(w.ifNoneMatch, etag) {
+			header.Set("ETag", compress.ETag(etag, w.encoding))
+		}
+
+		return
+	case http.StatusNoContent, http.StatusPartialContent:
+		return
+	}
+
+	contentType := header.Get(echo.HeaderContentType)
+
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		return
+	}
+
+	if length, err := strconv.Atoi(header.Get(echo.HeaderContentLength)); err == nil && length < compressMinLength {
+		return
+	}
+
+	writer, err := compress.NewWriter(w.encoding, w.ResponseWriter)
+	if err != nil {
+		return
+	}
+
+	w.writer = writer
+
+	header.Set(echo.HeaderContentEncoding, w.encoding)
+	header.Del(echo.HeaderContentLength)
+	// Byte ranges refer to the identity encoding
+	header.Del("Accept-Ranges")
+
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", compress.ETag(etag, w.encoding))
+	}
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	w.decide(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.writer != nil {
+		return w.writer.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Flush() {
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w *compressWriter) Close() error {
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+
+	return nil
+}
+
+// Report whether a list of entity tags, as found in If-None-Match, has etag
+func hasETag(etags, etag string) bool {
+	for _, candidate := range strings.Split(etags, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
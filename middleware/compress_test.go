@@ -0,0 +1,155 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Conditional requests for documents stored compressed, sent as they are
+func TestCompressETag(t *testing.T) {
+	e := echo.New()
+	e.Use(Compress())
+	e.GET("/", func(ctx echo.Context) error {
+		header := ctx.Response().Header()
+		header.Set("ETag", `"digest"`)
+		header.Set(echo.HeaderContentEncoding, "zstd")
+
+		if ctx.Request().Header.Get("If-None-Match") == `"digest"` {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+
+		return ctx.Blob(http.StatusOK, "text/plain", []byte("stored"))
+	})
+
+	tests := []struct {
+		ifNoneMatch string
+		code        int
+	}{
+		{"", http.StatusOK},
+		{`"digest-zstd"`, http.StatusNotModified},
+		{`"other-zstd"`, http.StatusOK},
+		{`"digest"`, http.StatusNotModified},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, "gzip, br, zstd")
+
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("If-None-Match %s: got %d, expected %d", test.ifNoneMatch, rec.Code, test.code)
+		}
+
+		if etag := rec.Header().Get("ETag"); etag != `"digest-zstd"` {
+			t.Errorf("If-None-Match %s: got ETag %s", test.ifNoneMatch, etag)
+		}
+	}
+}
+
+// Responses compressed on the fly
+func TestCompressETagOnTheFly(t *testing.T) {
+	e := echo.New()
+	e.Use(Compress())
+	e.GET("/", func(ctx echo.Context) error {
+		ctx.Response().Header().Set("ETag", `"digest"`)
+
+		if ctx.Request().Header.Get("If-None-Match") == `"digest"` {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+
+		return ctx.Blob(http.StatusOK, "text/plain", make([]byte, 2*compressMinLength))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	etag := rec.Header().Get("ETag")
+
+	if rec.Header().Get(echo.HeaderContentEncoding) != "gzip" || etag != `"digest-gzip"` {
+		t.Fatalf("got encoding %q and ETag %s", rec.Header().Get(echo.HeaderContentEncoding), etag)
+	}
+
+	req.Header.Set("If-None-Match", etag)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != etag {
+		t.Errorf("got %d and ETag %s, expected 304 and %s", rec.Code, rec.Header().Get("ETag"), etag)
+	}
+}
+
+// Responses too short to be compressed keep their identity entity tag, on
+// revalidation too
+func TestCompressETagShort(t *testing.T) {
+	e := echo.New()
+	e.Use(Compress())
+	e.GET("/", func(ctx echo.Context) error {
+		ctx.Response().Header().Set("ETag", `"digest"`)
+
+		if ctx.Request().Header.Get("If-None-Match") == `"digest"` {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+
+		ctx.Response().Header().Set(echo.HeaderContentLength, "5")
+
+		return ctx.Blob(http.StatusOK, "text/plain", []byte("short"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	etag := rec.Header().Get("ETag")
+
+	if rec.Header().Get(echo.HeaderContentEncoding) != "" || etag != `"digest"` {
+		t.Fatalf("got encoding %q and ETag %s", rec.Header().Get(echo.HeaderContentEncoding), etag)
+	}
+
+	req.Header.Set("If-None-Match", etag)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != etag {
+		t.Errorf("got %d and ETag %s, expected 304 and %s", rec.Code, rec.Header().Get("ETag"), etag)
+	}
+}
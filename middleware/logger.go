@@ -0,0 +1,109 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nekobin/nekobin/logger"
+)
+
+// Middleware to log requests. Each one gets an ID, taken from X-Request-ID or
+// generated, and a logger carrying it, both in the echo and request contexts.
+func Logger() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+
+			if !isRequestID(id) {
+				id = newRequestID()
+			}
+
+			ctx.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			log := logger.Default.With("request_id", id)
+			ctx.Set("logger", log)
+			ctx.SetRequest(req.WithContext(logger.NewContext(req.Context(), log)))
+
+			start := time.Now()
+			err := next(ctx)
+
+			// Handle errors here, so that the status logged is the one sent
+			if err != nil {
+				ctx.Error(err)
+			}
+
+			status := ctx.Response().Status
+			fields := []interface{}{
+				"method", req.Method,
+				"path", req.URL.Path,
+				"route", ctx.Path(),
+				"status", status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"bytes", ctx.Response().Size,
+				"remote_ip", ctx.RealIP(),
+			}
+
+			switch {
+			case err != nil:
+				log.Error("request failed", append(fields, "error", err)...)
+			case status >= http.StatusInternalServerError:
+				log.Error("request", fields...)
+			default:
+				log.Info("request", fields...)
+			}
+
+			return nil
+		}
+	}
+}
+
+// Incoming IDs are logged as they are: keep them short and printable.
+func isRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
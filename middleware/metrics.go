@@ -0,0 +1,95 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nekobin_http_requests_total",
+		Help: "HTTP requests handled, per route and status.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "nekobin_http_request_duration_seconds",
+		Help: "Time taken to handle HTTP requests, per route and status.",
+	}, []string{"route", "method", "status"})
+
+	limiterRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nekobin_limiter_rejections_total",
+		Help: "Requests rejected by rate limiters, per route.",
+	}, []string{"route"})
+
+	limiterThrottles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nekobin_limiter_throttled_total",
+		Help: "Requests whose body was read slower by rate limiters, per route.",
+	}, []string{"route"})
+)
+
+// Middleware to count requests and measure their latency
+func Metrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			start := time.Now()
+			err := next(ctx)
+
+			status := responseStatus(ctx, err)
+			route := ctx.Path()
+
+			if route == "" {
+				route = "unmatched"
+			}
+
+			labels := []string{route, ctx.Request().Method, strconv.Itoa(status)}
+
+			requestsTotal.WithLabelValues(labels...).Inc()
+			requestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+
+			return err
+		}
+	}
+}
+
+// The status of the response, or the one that will be sent for err. Errors
+// are turned into responses further up.
+func responseStatus(ctx echo.Context, err error) int {
+	if err == nil {
+		return ctx.Response().Status
+	}
+
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+
+	return http.StatusInternalServerError
+}
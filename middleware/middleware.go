@@ -25,16 +25,21 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"io/ioutil"
-	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	mw "github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/nekobin/nekobin/config"
 	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/health"
 	"github.com/nekobin/nekobin/limiter"
+	"github.com/nekobin/nekobin/logger"
 	"github.com/nekobin/nekobin/response"
+	"github.com/nekobin/nekobin/tracing"
 )
 
 // Middleware to add the configuration in handlers
@@ -57,11 +62,21 @@ func Database(db *database.Database) echo.MiddlewareFunc {
 	}
 }
 
+// Middleware to add the health state in handlers
+func Health(state *health.State) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("health", state)
+			return next(ctx)
+		}
+	}
+}
+
 // Middleware to make the About document available in handlers
 func About() echo.MiddlewareFunc {
 	file, err := ioutil.ReadFile("./README.md")
 	if err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("reading the About document", "error", err)
 	}
 
 	about := &database.Document{
@@ -83,7 +98,14 @@ func Limiter(limits []limiter.Limit) echo.MiddlewareFunc {
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
-			if !lim.IsAllowed(ctx.RealIP()) {
+			_, span := tracing.Start(ctx.Request().Context(), "Limiter.IsAllowed")
+			allowed := lim.IsAllowed(ctx.RealIP())
+			span.SetAttributes(attribute.Bool("limiter.allowed", allowed))
+			span.End()
+
+			if !allowed {
+				limiterRejections.WithLabelValues(ctx.Path()).Inc()
+
 				return ctx.JSON(
 					http.StatusTooManyRequests,
 					response.ErrorTooFast,
@@ -94,3 +116,32 @@ func Limiter(limits []limiter.Limit) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// Middleware to limit the rate at which request bodies are read. Clients
+// going faster are slowed down rather than rejected.
+func BodyLimiter(limits []limiter.Limit) echo.MiddlewareFunc {
+	lim := limiter.NewLimiter(limits...)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			body := limiter.NewReader(req.Context(), lim, ctx.RealIP(), req.Body)
+			req.Body = ioutil.NopCloser(body)
+
+			err := next(ctx)
+
+			if body.Throttled() {
+				limiterThrottles.WithLabelValues(ctx.Path()).Inc()
+			}
+
+			return err
+		}
+	}
+}
+
+// Middleware to require a bearer token, for endpoints which are not public
+func Token(token string) echo.MiddlewareFunc {
+	return mw.KeyAuth(func(key string, ctx echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+	})
+}
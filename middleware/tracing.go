@@ -0,0 +1,88 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/nekobin/nekobin/logger"
+	"github.com/nekobin/nekobin/tracing"
+)
+
+// Middleware to trace requests, continuing the traces of incoming traceparent headers
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			propagator := otel.GetTextMapPropagator()
+			parent := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+			spanCtx, span := tracing.Tracer().Start(
+				parent,
+				req.Method+" "+ctx.Path(),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethod(req.Method),
+					semconv.HTTPRoute(ctx.Path()),
+					semconv.URLPath(req.URL.Path),
+					semconv.ClientAddress(ctx.RealIP()),
+				),
+			)
+			defer span.End()
+
+			// Tie logs to the trace
+			if log, ok := ctx.Get("logger").(*logger.Logger); ok && span.SpanContext().IsValid() {
+				log = log.With("trace_id", span.SpanContext().TraceID().String())
+				ctx.Set("logger", log)
+				spanCtx = logger.NewContext(spanCtx, log)
+			}
+
+			ctx.SetRequest(req.WithContext(spanCtx))
+			propagator.Inject(spanCtx, propagation.HeaderCarrier(ctx.Response().Header()))
+
+			err := next(ctx)
+			status := responseStatus(ctx, err)
+
+			span.SetAttributes(semconv.HTTPStatusCode(status))
+
+			if err != nil {
+				span.RecordError(err)
+			}
+
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+
+			return err
+		}
+	}
+}
@@ -25,82 +25,103 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
 	"io"
 	"os"
+	"text/tabwriter"
 
-	"github.com/labstack/echo/v4"
-	mw "github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
 
 	"github.com/nekobin/nekobin/config"
-	"github.com/nekobin/nekobin/database"
-	"github.com/nekobin/nekobin/handlers"
-	"github.com/nekobin/nekobin/middleware"
+	"github.com/nekobin/nekobin/logger"
 )
 
-type Template struct {
-	templates *template.Template
+type command struct {
+	name string
+	args string
+	help string
+	run  func(cfg *config.Config, args []string) error
 }
 
-func (t *Template) Render(w io.Writer, name string, data interface{}, _ echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, data)
+var commands = []command{
+	{"serve", "", "serve nekobin, the default", serve},
+	{"get", "[--json] <key>", "print a document", get},
+	{"delete", "<key>", "delete a document", deleteDocument},
+	{"purge", "[--dry-run] --older-than <age>", "delete documents older than age, such as 720h or 30d", purge},
+	{"stats", "", "print what the database holds", stats},
+	{"import", "[--from format [--keys file] [--dry-run]] [file]", "restore an export, from stdin by default, or import pastes from hastebin, privatebin or stikked", importDocuments},
+	{"export", "[file]", "back up every document into a tar archive, gzipped if file ends in .gz", exportDocuments},
+	{"migrate", "up | down [n] | status", "apply, revert or list schema migrations", migrate},
+	{"check-config", "", "check the configuration", checkConfig},
 }
 
+// Returned by commands given wrong arguments
+var errUsage = errors.New("usage")
+
 func main() {
-	e := echo.New()
+	path := flag.String("config", "config.yaml", "path of the configuration file")
+	flag.Usage = func() { usage(os.Stderr) }
+	flag.Parse()
+
+	name, args := "serve", flag.Args()
 
-	e.HideBanner = true
-	e.Renderer = &Template{
-		templates: template.Must(
-			template.ParseGlob("./assets/templates/*"),
-		),
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	cfg := config.Load("config.yaml")
-	db := database.NewDatabase(&cfg.Database)
-
-	e.Use(
-		mw.LoggerWithConfig(
-			mw.LoggerConfig{
-				Format: "[${time_rfc3339}] ${status} ${method} ${path} (${remote_ip}) ${latency_human}\n",
-				Output: os.Stdout,
-			},
-		),
-		mw.Recover(),
-		middleware.Config(cfg),
-		middleware.Database(db),
-		middleware.About(),
-	)
-
-	e.Static("/static", "./assets/static")
-
-	root := e.Group("")
-	{
-		root.GET("/", handlers.GetRoot)
-		root.GET("/:key", handlers.GetRoot)
-
-		getLimiter := middleware.Limiter(cfg.Limits.Documents.Get)
-		postLimiter := middleware.Limiter(cfg.Limits.Documents.Post)
-
-		api := root.Group("/api")
-		{
-			documents := api.Group("/documents")
-			{
-				documents.GET("/about.md", handlers.GetAbout)
-				documents.GET("/:key", handlers.GetDocument, getLimiter)
-				documents.POST("", handlers.PostDocument, postLimiter)
-			}
-
-			api.GET("/ping", handlers.Pong)
+	// Needs no configuration
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+
+	var cmd *command
+
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
 		}
+	}
+
+	if cmd == nil {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	cfg := config.Load(*path)
+
+	// Commands may print their output on stdout, only the server logs there
+	out := os.Stderr
 
-		raw := root.Group("/raw")
-		{
-			raw.GET("/:key", handlers.GetRawDocument, getLimiter)
+	if cmd.name == "serve" {
+		out = os.Stdout
+	}
+
+	logger.Default = logger.New(out, cfg.Nekobin.LogLevel)
+
+	if err := cmd.run(cfg, args); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: nekobin %s %s\n", cmd.name, cmd.args)
+			os.Exit(2)
 		}
+
+		fmt.Fprintf(os.Stderr, "nekobin %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintln(out, "usage: nekobin [--config path] [command]")
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
 	}
 
-	e.Logger.Fatal(e.Start(fmt.Sprintf("%v:%v", cfg.Nekobin.Host, cfg.Nekobin.Port)))
+	fmt.Fprintf(w, "  help\tprint this list\n")
+	w.Flush()
 }
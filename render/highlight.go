@@ -0,0 +1,115 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package render
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// Chroma styles matching the frontend dark and light themes.
+var themes = map[string]*chroma.Style{
+	"dark":  styles.Get("monokai"),
+	"light": styles.Get("github"),
+}
+
+var stylesheets = make(map[string]string)
+
+func init() {
+	formatter := html.New(html.WithClasses(true))
+
+	for theme, style := range themes {
+		buf := &bytes.Buffer{}
+
+		if err := formatter.WriteCSS(buf, style); err != nil {
+			panic(err)
+		}
+
+		stylesheets[theme] = buf.String()
+	}
+}
+
+type HighlightOptions struct {
+	LineNumbers bool
+	// Number of the first line, when highlighting only a part of a document.
+	FirstLine int
+}
+
+// Stylesheet returns the CSS for highlighted code in the given theme.
+func Stylesheet(theme string) (css string, ok bool) {
+	css, ok = stylesheets[theme]
+	return
+}
+
+// IsTheme reports whether theme is a known theme name.
+func IsTheme(theme string) bool {
+	_, ok := themes[theme]
+	return ok
+}
+
+// Highlight renders content as HTML, highlighted according to language.
+// Unknown or empty languages are rendered as plain text.
+func Highlight(content, language string, opts HighlightOptions) (template.HTML, error) {
+	lexer := lexers.Get(language)
+
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, content)
+	if err != nil {
+		return "", err
+	}
+
+	firstLine := opts.FirstLine
+
+	if firstLine < 1 {
+		firstLine = 1
+	}
+
+	formatter := html.New(
+		html.WithClasses(true),
+		html.TabWidth(4),
+		html.WithLineNumbers(opts.LineNumbers),
+		html.LineNumbersInTable(true),
+		html.LinkableLineNumbers(true, "L"),
+		html.BaseLineNumber(firstLine),
+	)
+
+	buf := &strings.Builder{}
+
+	// The style only matters for inline styles, we always use classes.
+	if err := formatter.Format(buf, themes["dark"], iterator); err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil
+}
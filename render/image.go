@@ -0,0 +1,196 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	imageFontSize = 14
+	imagePadding  = 12
+	imageTabWidth = 4
+)
+
+// Colors from the frontend stylesheet (app.css)
+type palette struct {
+	background image.Image
+	gutter     image.Image
+	border     image.Image
+	text       image.Image
+	lineNumber image.Image
+}
+
+var palettes = map[string]*palette{
+	"dark": {
+		background: image.NewUniform(color.RGBA{0x2B, 0x2B, 0x2B, 0xFF}),
+		gutter:     image.NewUniform(color.RGBA{0x3C, 0x3F, 0x41, 0xFF}),
+		border:     image.NewUniform(color.RGBA{0x46, 0x46, 0x46, 0xFF}),
+		text:       image.NewUniform(color.RGBA{0xBA, 0xBA, 0xBA, 0xFF}),
+		lineNumber: image.NewUniform(color.RGBA{0x88, 0x88, 0x88, 0xFF}),
+	},
+	"light": {
+		background: image.NewUniform(color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}),
+		gutter:     image.NewUniform(color.RGBA{0xEC, 0xEC, 0xEC, 0xFF}),
+		border:     image.NewUniform(color.RGBA{0xE4, 0xE4, 0xE4, 0xFF}),
+		text:       image.NewUniform(color.RGBA{0x3C, 0x3F, 0x41, 0xFF}),
+		lineNumber: image.NewUniform(color.RGBA{0x88, 0x88, 0x88, 0xFF}),
+	},
+}
+
+var monospace *opentype.Font
+
+func init() {
+	var err error
+
+	monospace, err = opentype.Parse(gomono.TTF)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type ImageOptions struct {
+	Theme string
+	// Number of the first line, when drawing only a part of a document.
+	FirstLine int
+	// Lines and columns past these are cut off.
+	MaxLines   int
+	MaxColumns int
+}
+
+// Image draws content as a PNG image, along with line numbers.
+func Image(w io.Writer, content string, opts ImageOptions) error {
+	colors, ok := palettes[opts.Theme]
+
+	if !ok {
+		colors = palettes["dark"]
+	}
+
+	// Faces are not safe for concurrent use: get a new one each time.
+	face, err := opentype.NewFace(monospace, &opentype.FaceOptions{
+		Size:    imageFontSize,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer face.Close()
+
+	lines := imageLines(content, opts.MaxLines, opts.MaxColumns)
+
+	firstLine := opts.FirstLine
+
+	if firstLine < 1 {
+		firstLine = 1
+	}
+
+	columns := 1
+
+	for _, line := range lines {
+		if n := len(line); n > columns {
+			columns = n
+		}
+	}
+
+	digits := len(strconv.Itoa(firstLine + len(lines) - 1))
+
+	advance, _ := face.GlyphAdvance('M')
+	charWidth := advance.Ceil()
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil() + 4
+
+	gutterWidth := digits*charWidth + 2*imagePadding
+	width := gutterWidth + columns*charWidth + 2*imagePadding
+	height := len(lines)*lineHeight + 2*imagePadding
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	draw.Draw(img, img.Bounds(), colors.background, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, gutterWidth, height), colors.gutter, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(gutterWidth-1, 0, gutterWidth, height), colors.border, image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{Dst: img, Face: face}
+
+	for i, line := range lines {
+		baseline := fixed.I(imagePadding + i*lineHeight + metrics.Ascent.Ceil())
+
+		number := strconv.Itoa(firstLine + i)
+		drawer.Src = colors.lineNumber
+		drawer.Dot = fixed.Point26_6{
+			X: fixed.I(gutterWidth - imagePadding - len(number)*charWidth),
+			Y: baseline,
+		}
+		drawer.DrawString(number)
+
+		drawer.Src = colors.text
+		drawer.Dot = fixed.Point26_6{
+			X: fixed.I(gutterWidth + imagePadding),
+			Y: baseline,
+		}
+		drawer.DrawString(string(line))
+	}
+
+	return png.Encode(w, img)
+}
+
+// Split content in lines of runes, expanding tabs and cutting off whatever
+// exceeds the limits (zero means no limit).
+func imageLines(content string, maxLines, maxColumns int) [][]rune {
+	content = strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	split := strings.Split(content, "\n")
+
+	if maxLines > 0 && len(split) > maxLines {
+		split = split[:maxLines]
+	}
+
+	lines := make([][]rune, len(split))
+
+	for i, line := range split {
+		line = strings.ReplaceAll(line, "\t", strings.Repeat(" ", imageTabWidth))
+		runes := []rune(line)
+
+		if maxColumns > 0 && len(runes) > maxColumns {
+			runes = append(runes[:maxColumns-1], '…')
+		}
+
+		lines[i] = runes
+	}
+
+	return lines
+}
@@ -41,7 +41,16 @@ var (
 	ErrorInvalidData      = NewError("INVALID_DATA")
 	ErrorTitleTooLong     = NewError("TITLE_TOO_LONG")
 	ErrorAuthorTooLong    = NewError("AUTHOR_TOO_LONG")
+	ErrorInvalidLanguage  = NewError("INVALID_LANGUAGE")
 	ErrorContentEmpty     = NewError("CONTENT_EMPTY")
 	ErrorContentTooLong   = NewError("CONTENT_TOO_LONG")
+	ErrorContentNotText   = NewError("CONTENT_NOT_TEXT")
+	ErrorDocumentTooLarge = NewError("DOCUMENT_TOO_LARGE")
+	ErrorInvalidLineRange = NewError("INVALID_LINE_RANGE")
+	ErrorUploadNotFound   = NewError("UPLOAD_NOT_FOUND")
+	ErrorUploadExpired    = NewError("UPLOAD_EXPIRED")
+	ErrorUploadConflict   = NewError("UPLOAD_CONFLICT")
+	ErrorUploadLocked     = NewError("UPLOAD_LOCKED")
+	ErrorUnsupportedTus   = NewError("UNSUPPORTED_TUS_VERSION")
 	ErrorTooFast          = NewError("TOO_FAST")
 )
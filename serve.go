@@ -0,0 +1,255 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	mw "github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/nekobin/nekobin/config"
+	"github.com/nekobin/nekobin/database"
+	"github.com/nekobin/nekobin/handlers"
+	"github.com/nekobin/nekobin/health"
+	"github.com/nekobin/nekobin/logger"
+	"github.com/nekobin/nekobin/middleware"
+	"github.com/nekobin/nekobin/storage"
+	"github.com/nekobin/nekobin/tracing"
+)
+
+type Template struct {
+	templates *template.Template
+}
+
+func (t *Template) Render(w io.Writer, name string, data interface{}, _ echo.Context) error {
+	return t.templates.ExecuteTemplate(w, name, data)
+}
+
+// Serve until SIGINT or SIGTERM, then shut down gracefully
+func serve(cfg *config.Config, _ []string) error {
+	e := echo.New()
+
+	e.HideBanner = true
+	e.Renderer = &Template{
+		templates: template.Must(
+			template.ParseGlob("./assets/templates/*"),
+		),
+	}
+
+	shutdownTracing, err := tracing.Setup(&cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	store, err := storage.NewStore(&cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("opening the blob store: %w", err)
+	}
+
+	db := database.NewDatabase(&cfg.Database, store, cfg.Storage.Threshold)
+	state := health.NewState()
+
+	// Clean up expired uploads and orphan blobs, until shutting down
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	cleanupDone := make(chan struct{})
+
+	go func() {
+		defer close(cleanupDone)
+
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-cleanupCtx.Done():
+				return
+			}
+
+			if _, err := db.Uploads.DeleteExpired(cleanupCtx); err != nil {
+				logger.Default.Error("deleting expired uploads", "error", err)
+			}
+
+			if _, err := db.Documents.Sweep(cleanupCtx); err != nil {
+				logger.Default.Error("sweeping orphan blobs", "error", err)
+			}
+		}
+	}()
+
+	e.Use(
+		middleware.Logger(),
+		middleware.Tracing(),
+		middleware.Metrics(),
+		mw.Recover(),
+		middleware.Compress(),
+		middleware.Config(cfg),
+		middleware.Database(db),
+		middleware.Health(state),
+		middleware.About(),
+	)
+
+	e.Static("/static", "./assets/static")
+
+	root := e.Group("")
+	{
+		getLimiter := middleware.Limiter(cfg.Limits.Documents.Get)
+		postLimiter := middleware.Limiter(cfg.Limits.Documents.Post)
+
+		root.GET("/", handlers.GetRoot)
+		root.GET("/:key", handlers.GetRoot, getLimiter)
+		root.GET("/healthz", handlers.GetHealthz)
+		root.GET("/readyz", handlers.GetReadyz)
+		root.GET("/highlight/:theme", handlers.GetHighlightStylesheet)
+		root.GET("/oembed", handlers.GetOEmbed, getLimiter)
+
+		api := root.Group("/api")
+		{
+			documents := api.Group("/documents")
+			{
+				documents.GET("/about.md", handlers.GetAbout)
+				documents.GET("/:key", handlers.GetDocument, getLimiter)
+				documents.POST("", handlers.PostDocument, postLimiter)
+			}
+
+			uploads := api.Group("/uploads", middleware.Tus())
+			{
+				uploadLimiter := middleware.BodyLimiter(cfg.Limits.Uploads)
+
+				uploads.OPTIONS("", handlers.GetUploadOptions)
+				uploads.POST("", handlers.PostUpload, postLimiter)
+				uploads.HEAD("/:id", handlers.HeadUpload, getLimiter)
+				uploads.PATCH("/:id", handlers.PatchUpload, uploadLimiter)
+				uploads.DELETE("/:id", handlers.DeleteUpload)
+			}
+
+			api.GET("/stats", handlers.GetStats)
+			api.GET("/ping", handlers.Pong)
+		}
+
+		raw := root.Group("/raw")
+		{
+			raw.GET("/:key", handlers.GetRawDocument, getLimiter)
+		}
+
+		md := root.Group("/md")
+		{
+			md.GET("/:key", handlers.GetMarkdownDocument, getLimiter)
+		}
+
+		img := root.Group("/img")
+		{
+			img.GET("/:key", handlers.GetDocumentImage, getLimiter)
+		}
+
+		embed := root.Group("/embed")
+		{
+			// Both /embed/:key and /embed/:key.js
+			embed.GET("/:key", handlers.GetEmbed, getLimiter)
+		}
+	}
+
+	var admin *echo.Echo
+
+	// Metrics go on the admin listener if any, otherwise along the rest behind a token
+	{
+		var auth []echo.MiddlewareFunc
+
+		if cfg.Metrics.Token != "" {
+			auth = append(auth, middleware.Token(cfg.Metrics.Token))
+		}
+
+		switch {
+		case cfg.Metrics.Listen != "":
+			admin = echo.New()
+			admin.HideBanner = true
+			admin.HidePort = true
+			admin.GET("/metrics", echo.WrapHandler(promhttp.Handler()), auth...)
+
+			go func() {
+				if err := admin.Start(cfg.Metrics.Listen); err != http.ErrServerClosed {
+					logger.Default.Fatal("serving metrics", "error", err)
+				}
+			}()
+		case cfg.Metrics.Token != "":
+			e.GET("/metrics", echo.WrapHandler(promhttp.Handler()), auth...)
+		}
+	}
+
+	go func() {
+		if err := e.Start(fmt.Sprintf("%v:%v", cfg.Nekobin.Host, cfg.Nekobin.Port)); err != http.ErrServerClosed {
+			logger.Default.Fatal("serving", "error", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	// Fail readiness first, giving load balancers time to stop sending requests
+	state.Drain()
+	logger.Default.Info("shutting down", "delay", cfg.Nekobin.ShutdownDelay)
+	time.Sleep(cfg.Nekobin.ShutdownDelay)
+
+	// Stop accepting connections and wait for requests in flight
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Nekobin.ShutdownTimeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		logger.Default.Error("draining requests", "error", err)
+	}
+
+	if admin != nil {
+		if err := admin.Shutdown(ctx); err != nil {
+			logger.Default.Error("draining metrics requests", "error", err)
+		}
+	}
+
+	// Interrupt the cleanup, the next start will pick it up
+	stopCleanup()
+	<-cleanupDone
+
+	// Write pending views, then close the connections
+	if err := db.Close(); err != nil {
+		logger.Default.Error("closing the database", "error", err)
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Default.Error("flushing spans", "error", err)
+	}
+
+	logger.Default.Info("shut down")
+
+	return nil
+}
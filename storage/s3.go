@@ -0,0 +1,337 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nekobin/nekobin/config"
+)
+
+// S3 stores blobs in a bucket of an S3-compatible object storage (AWS, MinIO,
+// Ceph...), using path-style requests signed with AWS Signature Version 4.
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func NewS3(cfg *config.S3) (*S3, error) {
+	scheme := "http"
+
+	if cfg.UseSSL {
+		scheme = "https"
+	}
+
+	endpoint, err := url.Parse(scheme + "://" + cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	region := cfg.Region
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3{
+		client:    &http.Client{},
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		region:    region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+	}, nil
+}
+
+func (s3 *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	res, err := s3.do(ctx, http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+
+	return s3.discard(res, http.StatusOK)
+}
+
+// Get returns an io.ReadSeeker as well, provided the size of the object is
+// known: reading after seeking elsewhere issues a ranged request.
+func (s3 *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s3.get(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ContentLength < 0 {
+		return res.Body, nil
+	}
+
+	return &s3Object{s3: s3, ctx: ctx, key: key, size: res.ContentLength, body: res.Body}, nil
+}
+
+// Get an object from offset on
+func (s3 *S3) get(ctx context.Context, key string, offset int64) (*http.Response, error) {
+	header := make(http.Header)
+	expected := http.StatusOK
+
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		expected = http.StatusPartialContent
+	}
+
+	res, err := s3.do(ctx, http.MethodGet, key, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != expected {
+		return nil, s3.discard(res, expected)
+	}
+
+	return res, nil
+}
+
+func (s3 *S3) Delete(ctx context.Context, key string) error {
+	res, err := s3.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	// Deleting missing objects is not an error either
+	return s3.discard(res, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+}
+
+func (s3 *S3) Ping(ctx context.Context) error {
+	res, err := s3.do(ctx, http.MethodHead, "", nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return s3.discard(res, http.StatusOK)
+}
+
+// Close the response, turning unexpected status codes into errors
+func (s3 *S3) discard(res *http.Response, expected ...int) error {
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+
+	for _, code := range expected {
+		if res.StatusCode == code {
+			return nil
+		}
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	return fmt.Errorf("s3: %s: %s", res.Status, strings.TrimSpace(string(body)))
+}
+
+func (s3 *S3) do(ctx context.Context, method, key string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *s3.endpoint
+	u.Path = "/" + s3.bucket
+
+	if key != "" {
+		u.Path += "/" + key
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s3.sign(req, time.Now().UTC())
+
+	return s3.client.Do(req)
+}
+
+// AWS Signature Version 4. The payload is unsigned unless its hash is set in
+// X-Amz-Content-Sha256 already, so that bodies can be streamed instead of
+// being hashed upfront. Range is signed too.
+func (s3 *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s3.region + "/s3/aws4_request"
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+	if req.Header.Get("Range") != "" {
+		names = append(names, "range")
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		value := req.Header.Get(name)
+
+		if name == "host" {
+			value = req.URL.Host
+		}
+
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s3.secretKey), date)
+	key = hmacSHA256(key, s3.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// An object of known size, read from the body of a request starting at its
+// current offset. Seeking elsewhere drops the body, the next read issues a
+// ranged request.
+type s3Object struct {
+	s3  *S3
+	ctx context.Context
+	key string
+
+	size   int64
+	offset int64
+
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body != nil && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+
+	if o.body == nil {
+		res, err := o.s3.get(o.ctx, o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+
+		o.body, o.bodyOffset = res.Body, o.offset
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset = o.offset
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+
+	o.offset = offset
+
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	return o.body.Close()
+}
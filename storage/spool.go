@@ -0,0 +1,113 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 Dan <https://github.com/delivrance>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// How much of the content is kept in memory for inspection
+const spoolHeadSize = 32 * 1024
+
+var ErrTooLarge = errors.New("content too large")
+
+// Spool is content of unknown size buffered to a temporary file, so that it
+// never needs to be held in memory, along with its size and digest.
+type Spool struct {
+	*os.File
+
+	Size   int64
+	Digest string
+	// The first bytes of the content
+	Head []byte
+}
+
+// NewSpool buffers r into a temporary file in dir (the default temporary
+// directory if empty). It fails with ErrTooLarge past limit bytes.
+func NewSpool(dir string, r io.Reader, limit int64) (*Spool, error) {
+	file, err := ioutil.TempFile(dir, "nekobin-spool-")
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{File: file}
+	hash := sha256.New()
+	head := &headWriter{limit: spoolHeadSize}
+
+	size, err := io.Copy(io.MultiWriter(file, hash, head), io.LimitReader(r, limit+1))
+
+	if err == nil && size > limit {
+		err = ErrTooLarge
+	}
+
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	spool.Size = size
+	spool.Digest = hex.EncodeToString(hash.Sum(nil))
+	spool.Head = head.buf
+
+	return spool, nil
+}
+
+// Close and remove the temporary file
+func (s *Spool) Close() error {
+	err := s.File.Close()
+
+	if err := os.Remove(s.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return err
+}
+
+// Keeps the first limit bytes written to it
+type headWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := w.limit - len(w.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+
+		w.buf = append(w.buf, p[:n]...)
+	}
+
+	return len(p), nil
+}